@@ -2,13 +2,28 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/diwise/integration-incident/internal/pkg/application"
+	"github.com/diwise/integration-incident/internal/pkg/application/categories"
+	"github.com/diwise/integration-incident/internal/pkg/application/descriptions"
+	"github.com/diwise/integration-incident/internal/pkg/application/devices"
+	"github.com/diwise/integration-incident/internal/pkg/application/dryrun"
+	"github.com/diwise/integration-incident/internal/pkg/application/locale"
+	"github.com/diwise/integration-incident/internal/pkg/application/locations"
+	"github.com/diwise/integration-incident/internal/pkg/application/outbox"
+	"github.com/diwise/integration-incident/internal/pkg/application/rules"
+	"github.com/diwise/integration-incident/internal/pkg/application/seasons"
 	"github.com/diwise/integration-incident/internal/pkg/application/services"
+	"github.com/diwise/integration-incident/internal/pkg/application/tenants"
+	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/state"
+	"github.com/diwise/integration-incident/internal/pkg/infrastructure/sinks"
 	"github.com/diwise/integration-incident/internal/pkg/presentation"
 	"github.com/diwise/integration-incident/pkg/incident"
 	"github.com/diwise/service-chassis/pkg/infrastructure/buildinfo"
@@ -27,28 +42,302 @@ func main() {
 
 	baseUrl := os.Getenv("DIWISE_BASE_URL")
 
-	gatewayUrl := env.GetVariableOrDie(ctx, "GATEWAY_URL", "valid gateway URL")
-	authCode := env.GetVariableOrDie(ctx, "AUTH_CODE", "valid auth code")
 	port := env.GetVariableOrDefault(ctx, "SERVICE_PORT", "8080")
-	tenant := env.GetVariableOrDefault(ctx, "DIWISE_TENANT", "default")
 
-	incidentReporter, err := incident.NewIncidentReporter(ctx, gatewayUrl, authCode)
+	tenantConfig, multiTenant, err := loadTenants(ctx)
 	if err != nil {
-		fatal(ctx, "failed to create incident reporter", err)
+		fatal(ctx, "failed to load tenants", err)
 	}
 
-	entityLocator, err := services.NewEntityLocator(baseUrl, tenant)
+	catalogs := locale.Default()
+
+	if dir := os.Getenv("LOCALE_DIR"); dir != "" {
+		catalogs, err = locale.Load(dir)
+		if err != nil {
+			fatal(ctx, "failed to load locale catalogs", err)
+		}
+	}
+
+	dryRun, err := strconv.ParseBool(env.GetVariableOrDefault(ctx, "DRY_RUN", "false"))
+	if err != nil {
+		fatal(ctx, "invalid dry run setting", err)
+	}
+
+	var incidentReporter incident.CreatorFunc
+	var routerOptions []presentation.Option
+
+	incidentResolvers := map[string]incident.ResolverFunc{}
+
+	// incidents that the guards turn away are retried by the outbox, or kept
+	// on the queues of the guards, but never by both
+	outboxStoreType := os.Getenv("OUTBOX_STORE")
+	guards := map[string]*incident.Guard{}
+
+	queueSize, err := strconv.Atoi(env.GetVariableOrDefault(ctx, "INCIDENT_QUEUE_SIZE", "0"))
+	if err != nil {
+		fatal(ctx, "invalid incident queue size", err)
+	}
+
+	if queueSize > 0 && outboxStoreType != "" {
+		fatal(ctx, "invalid configuration", fmt.Errorf("INCIDENT_QUEUE_SIZE can not be used together with OUTBOX_STORE"))
+	}
+
+	if dryRun {
+		size, err := strconv.Atoi(env.GetVariableOrDefault(ctx, "DRY_RUN_BUFFER_SIZE", "500"))
+		if err != nil {
+			fatal(ctx, "invalid dry run buffer size", err)
+		}
+
+		recorder, err := dryrun.NewRecorder(size)
+		if err != nil {
+			fatal(ctx, "failed to create dry run recorder", err)
+		}
+
+		logger.Warn("running as a dry run, incidents are recorded but not posted")
+
+		// a dry run keeps its state in memory and has no outbox, so that a
+		// shadow run pointed at the volume of a real deployment can not
+		// suppress or post its incidents
+		if os.Getenv("STATE_STORE") != "" || outboxStoreType != "" {
+			logger.Warn("ignoring state store and outbox settings in dry run")
+		}
+		outboxStoreType = ""
+
+		incidentReporter = recorder.Reporter()
+		for _, name := range tenantConfig.Names() {
+			incidentResolvers[name] = recorder.Resolver()
+		}
+		routerOptions = append(routerOptions, presentation.WithRecorder(recorder))
+	} else {
+		var sinkConfig *sinks.Config
+
+		if path := os.Getenv("INCIDENT_SINKS_FILE"); path != "" {
+			sinkConfig, err = sinks.Load(path)
+			if err != nil {
+				fatal(ctx, "failed to load incident sinks", err)
+			}
+		}
+
+		if sinkConfig == nil || sinkConfig.Uses(sinks.TypeIncidentAPI) {
+			incidentReporters := map[string]incident.CreatorFunc{}
+
+			for _, name := range tenantConfig.Names() {
+				incidentClient, err := newIncidentClient(ctx, name, tenantConfig.Tenants[name])
+				if err != nil {
+					fatal(ctx, "failed to create incident client", err)
+				}
+
+				// each tenant has a guard of its own, so that an incident api
+				// that is down does not hold back the incidents of the others
+				guards[name], err = newGuard(ctx, name, incidentClient.Creator(), queueSize)
+				if err != nil {
+					fatal(ctx, "failed to create circuit breaker", err)
+				}
+
+				incidentReporters[name] = guards[name].Reporter()
+				incidentResolvers[name] = incidentClient.Resolver()
+
+				suffix := ""
+				if multiTenant {
+					suffix = "-" + name
+				}
+
+				routerOptions = append(routerOptions, presentation.WithHealthCheck("token"+suffix, func(context.Context) (any, error) {
+					status := incidentClient.TokenStatus()
+					return status, status.Err()
+				}), breakerStatus("breaker"+suffix, guards[name]))
+			}
+
+			incidentReporter = tenantConfig.Reporter(incidentReporters)
+		}
+
+		if sinkConfig != nil {
+			sinkRouter, err := sinks.NewRouter(sinkConfig, incidentReporter, catalogs)
+			if err != nil {
+				fatal(ctx, "failed to create incident sinks", err)
+			}
+			defer sinkRouter.Close()
+
+			incidentReporter = sinkRouter.Reporter()
+
+			if len(guards) == 0 {
+				guards["sinks"], err = newGuard(ctx, "sinks", incidentReporter, queueSize)
+				if err != nil {
+					fatal(ctx, "failed to create circuit breaker", err)
+				}
+
+				incidentReporter = guards["sinks"].Reporter()
+				routerOptions = append(routerOptions, breakerStatus("breaker", guards["sinks"]))
+			}
+		}
+	}
+
+	ruleSet := rules.Default()
+
+	if rulesFile := os.Getenv("INCIDENT_RULES_FILE"); rulesFile != "" {
+		ruleSet, err = rules.Load(rulesFile)
+		if err != nil {
+			fatal(ctx, "failed to load incident rules", err)
+		}
+	}
+
+	categoryMapping, err := loadCategories(ctx)
 	if err != nil {
-		fatal(ctx, "failed to create entity locator", err)
+		fatal(ctx, "failed to load category mapping", err)
+	}
+
+	for _, name := range tenantConfig.Names() {
+		err = ruleSet.ValidateCategories(categoryMapping.Merge(tenantConfig.Tenants[name].Categories).Category)
+		if err != nil {
+			fatal(ctx, "category mapping of tenant "+name+" does not cover all rules", err)
+		}
+	}
+
+	var descriptionTemplates *descriptions.Templates
+
+	if path := os.Getenv("INCIDENT_DESCRIPTIONS_FILE"); path != "" {
+		descriptionTemplates, err = descriptions.Load(path)
+		if err != nil {
+			fatal(ctx, "failed to load description templates", err)
+		}
+	}
+
+	deviceFilter := devices.Default()
+
+	if path := os.Getenv("DEVICE_FILTERS_FILE"); path != "" {
+		deviceFilter, err = devices.Load(path)
+		if err != nil {
+			fatal(ctx, "failed to load device filters", err)
+		}
+	}
+
+	freezeSeason := seasons.DefaultFreeze()
+
+	if path := os.Getenv("FREEZE_SEASON_FILE"); path != "" {
+		freezeSeason, err = seasons.LoadFreeze(path)
+		if err != nil {
+			fatal(ctx, "failed to load freeze season", err)
+		}
+	}
+
+	locationOverrides := locations.Overrides{}
+
+	if path := os.Getenv("DEVICE_LOCATIONS_FILE"); path != "" {
+		locationOverrides, err = locations.Load(path)
+		if err != nil {
+			fatal(ctx, "failed to load device locations", err)
+		}
+	}
+
+	stateStoreType := env.GetVariableOrDefault(ctx, "STATE_STORE", state.TypeMemory)
+	if dryRun {
+		stateStoreType = state.TypeMemory
+	}
+
+	stateStore, err := state.New(stateStoreType, env.GetVariableOrDefault(ctx, "STATE_STORE_PATH", "state.db"))
+	if err != nil {
+		fatal(ctx, "failed to create state store", err)
+	}
+	defer stateStore.Close()
+
+	routerOptions = append(routerOptions, presentation.WithCategories(categoryMapping), presentation.WithTenants(tenantConfig), presentation.WithDescriptions(descriptionTemplates))
+	routerOptions = append(routerOptions, presentation.WithAdminToken(os.Getenv("ADMIN_TOKEN")))
+
+	var incidentOutbox *outbox.Outbox
+
+	if outboxStoreType != "" {
+		outboxStore, err := outbox.NewStore(outboxStoreType, env.GetVariableOrDefault(ctx, "OUTBOX_STORE_PATH", "outbox.db"))
+		if err != nil {
+			fatal(ctx, "failed to create outbox store", err)
+		}
+		defer outboxStore.Close()
+
+		incidentOutbox, err = outbox.New(outboxStore, incidentReporter)
+		if err != nil {
+			fatal(ctx, "failed to create outbox", err)
+		}
+
+		incidentReporter = incidentOutbox.Reporter()
+		routerOptions = append(routerOptions, presentation.WithOutbox(incidentOutbox))
+	}
+
+	apps := map[string]application.IntegrationIncident{}
+
+	for _, name := range tenantConfig.Names() {
+		t := tenantConfig.Tenants[name]
+
+		language := t.Language
+		if language == "" {
+			language = env.GetVariableOrDefault(ctx, "INCIDENT_LANGUAGE", locale.DefaultLanguage)
+		}
+
+		catalog, err := catalogs.Get(language)
+		if err != nil {
+			fatal(ctx, "invalid language for tenant "+name, err)
+		}
+
+		freeze := freezeSeason
+		if t.FreezeSeason != nil {
+			freeze = t.FreezeSeason
+		}
+
+		entityLocator, err := services.NewEntityLocator(baseUrl, t.BrokerTenant)
+		if err != nil {
+			fatal(ctx, "failed to create entity locator", err)
+		}
+
+		opts := []application.Option{
+			application.WithStateStore(stateStore),
+			application.WithResolver(incidentResolvers[name]),
+			application.WithRules(ruleSet),
+			application.WithCategories(categoryMapping.Merge(t.Categories)),
+			application.WithLocationOverrides(locationOverrides),
+			application.WithDeviceFilter(deviceFilter),
+			application.WithLocale(catalog),
+			application.WithDescriptions(descriptionTemplates),
+			application.WithFreezeSeason(freeze),
+		}
+
+		// the state of a single tenant deployment is kept under the plain
+		// dedup keys, as it always has been
+		if multiTenant {
+			opts = append(opts, application.WithTenant(name))
+		}
+
+		apps[name] = application.NewApplication(ctx, incidentReporter, entityLocator, opts...)
 	}
 
-	app := application.NewApplication(ctx, incidentReporter, entityLocator)
+	app, err := tenants.NewDispatcher(tenantConfig, apps)
+	if err != nil {
+		fatal(ctx, "failed to create tenant dispatcher", err)
+	}
 
-	mux, err := presentation.CreateRouter(ctx, app)
+	mux, err := presentation.CreateRouter(ctx, app, routerOptions...)
 	if err != nil {
 		fatal(ctx, "failed to start router", err)
 	}
 
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+
+	for _, guard := range guards {
+		go guard.Run(workerCtx, app.IncidentCreated)
+	}
+
+	if incidentOutbox != nil {
+		go incidentOutbox.Run(workerCtx, app.IncidentCreated)
+	}
+
+	recheckInterval, err := time.ParseDuration(env.GetVariableOrDefault(ctx, "RECHECK_INTERVAL", "1m"))
+	if err == nil && recheckInterval <= 0 {
+		err = fmt.Errorf("interval must be positive")
+	}
+	if err != nil {
+		fatal(ctx, "invalid recheck interval", err)
+	}
+
+	go recheck(workerCtx, app, recheckInterval)
+
 	webServer := &http.Server{Addr: ":" + port, Handler: mux}
 	go func() {
 		if err := webServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -63,6 +352,8 @@ func main() {
 
 	logger.Debug("received signal", "signal", s)
 
+	stopWorkers()
+
 	err = webServer.Shutdown(ctx)
 	if err != nil {
 		logger.Error("failed to shutdown web server", "err", err.Error())
@@ -71,6 +362,141 @@ func main() {
 	logger.Info("shutting down")
 }
 
+// loadCategories starts from the default category mapping and applies the
+// entries from INCIDENT_CATEGORIES_FILE and then INCIDENT_CATEGORIES on top
+func loadCategories(ctx context.Context) (categories.Mapping, error) {
+	mapping := categories.Default()
+
+	if path := os.Getenv("INCIDENT_CATEGORIES_FILE"); path != "" {
+		m, err := categories.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		mapping = mapping.Merge(m)
+	}
+
+	if s := env.GetVariableOrDefault(ctx, "INCIDENT_CATEGORIES", ""); s != "" {
+		m, err := categories.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		mapping = mapping.Merge(m)
+	}
+
+	logging.GetFromContext(ctx).Info("using category mapping", "categories", mapping)
+
+	return mapping, nil
+}
+
+// loadTenants reads the tenants from TENANTS_FILE. Without a tenant file the
+// service serves the single tenant DIWISE_TENANT, with the incident API at
+// GATEWAY_URL and the municipality in MUNICIPALITY_CODE.
+func loadTenants(ctx context.Context) (*tenants.Config, bool, error) {
+	if path := os.Getenv("TENANTS_FILE"); path != "" {
+		cfg, err := tenants.Load(path)
+		if err != nil {
+			return nil, false, err
+		}
+
+		logging.GetFromContext(ctx).Info("serving multiple tenants", "tenants", cfg.Names(), "default", cfg.Default)
+
+		return cfg, true, nil
+	}
+
+	return tenants.Single(env.GetVariableOrDefault(ctx, "DIWISE_TENANT", "default"), tenants.Tenant{
+		GatewayURL:   os.Getenv("GATEWAY_URL"),
+		AuthCode:     os.Getenv("AUTH_CODE"),
+		Municipality: os.Getenv("MUNICIPALITY_CODE"),
+	}), false, nil
+}
+
+// newIncidentClient creates a client for the incident API of a tenant
+func newIncidentClient(ctx context.Context, name string, t tenants.Tenant) (*incident.Client, error) {
+	if t.GatewayURL == "" || t.AuthCode == "" {
+		return nil, fmt.Errorf("tenant %s requires a gateway url and an auth code", name)
+	}
+
+	municipality := t.Municipality
+	if municipality == "" {
+		municipality = env.GetVariableOrDefault(ctx, "MUNICIPALITY_CODE", incident.DefaultMunicipality)
+	}
+
+	closedStatus, err := strconv.Atoi(env.GetVariableOrDefault(ctx, "INCIDENT_CLOSED_STATUS", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid closed status: %w", err)
+	}
+
+	return incident.NewClient(ctx, t.AuthCode,
+		incident.WithBaseURL(t.GatewayURL),
+		incident.WithMunicipality(municipality),
+		incident.WithAPIVersion(env.GetVariableOrDefault(ctx, "INCIDENT_API_VERSION", incident.DefaultAPIVersion)),
+		incident.WithPathTemplate(env.GetVariableOrDefault(ctx, "INCIDENT_API_PATH", incident.DefaultPathTemplate)),
+		incident.WithClosedStatus(closedStatus),
+	)
+}
+
+// newGuard wraps the reporter with the rate limit and circuit breaker set by
+// INCIDENT_RATE_LIMIT (incidents per minute), INCIDENT_RATE_BURST,
+// BREAKER_THRESHOLD and BREAKER_OPEN_FOR, and a queue if queueSize is set
+func newGuard(ctx context.Context, name string, reporter incident.CreatorFunc, queueSize int) (*incident.Guard, error) {
+	perMinute, err := strconv.ParseFloat(env.GetVariableOrDefault(ctx, "INCIDENT_RATE_LIMIT", "60"), 64)
+	if err != nil {
+		return nil, err
+	}
+
+	burst, err := strconv.Atoi(env.GetVariableOrDefault(ctx, "INCIDENT_RATE_BURST", "10"))
+	if err != nil {
+		return nil, err
+	}
+
+	threshold, err := strconv.Atoi(env.GetVariableOrDefault(ctx, "BREAKER_THRESHOLD", "5"))
+	if err != nil {
+		return nil, err
+	}
+
+	openFor, err := time.ParseDuration(env.GetVariableOrDefault(ctx, "BREAKER_OPEN_FOR", "30s"))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []incident.GuardOption{
+		incident.WithName(name),
+		incident.WithRateLimit(perMinute, burst),
+		incident.WithBreaker(threshold, openFor),
+	}
+
+	if queueSize > 0 {
+		opts = append(opts, incident.WithQueue(queueSize))
+	}
+
+	return incident.NewGuard(reporter, opts...)
+}
+
+// breakerStatus reports the state of the circuit breaker of a guard on /health
+func breakerStatus(name string, guard *incident.Guard) presentation.Option {
+	return presentation.WithStatus(name, func(context.Context) any {
+		return guard.Status()
+	})
+}
+
+// recheck looks for silent sources and running timers at every interval until
+// the context is done
+func recheck(ctx context.Context, app application.IntegrationIncident, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.Recheck(ctx); err != nil {
+				logging.GetFromContext(ctx).Error("failed to recheck silent sources and running timers", "err", err.Error())
+			}
+		}
+	}
+}
+
 func fatal(ctx context.Context, msg string, err error) {
 	logging.GetFromContext(ctx).Error(msg, "err", err.Error())
 	os.Exit(1)
@@ -3,73 +3,175 @@ package application
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/diwise/integration-incident/internal/pkg/application/categories"
+	"github.com/diwise/integration-incident/internal/pkg/application/descriptions"
+	"github.com/diwise/integration-incident/internal/pkg/application/devices"
+	"github.com/diwise/integration-incident/internal/pkg/application/locale"
+	"github.com/diwise/integration-incident/internal/pkg/application/locations"
+	"github.com/diwise/integration-incident/internal/pkg/application/rules"
+	"github.com/diwise/integration-incident/internal/pkg/application/seasons"
 	"github.com/diwise/integration-incident/internal/pkg/application/services"
-	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/models"
+	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/state"
 	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:generate moq -rm -out application_mock.go . IntegrationIncident
 
 type IntegrationIncident interface {
 	DeviceStateUpdated(ctx context.Context, deviceId string, statusMessage models.StatusMessage) error
-	LifebuoyValueUpdated(ctx context.Context, deviceId, deviceValue string) error
+	LifebuoyValueUpdated(ctx context.Context, tenant, deviceId, deviceValue string) error
+	WeatherObserved(ctx context.Context, tenant, entityId string, temperature float64, observedAt time.Time) error
 	SewageOverflowObserved(ctx context.Context, functionUpdated models.FunctionUpdated) error
+	FunctionUpdated(ctx context.Context, functionUpdated models.FunctionUpdated) error
+	IncidentCreated(ctx context.Context, key, incidentID string) error
+	Recheck(ctx context.Context) error
 }
 
 var tracer = otel.Tracer("integration-incident/app")
 
-type cache struct {
-	mx    sync.Mutex
-	items map[string]string
+type app struct {
+	incidentReporter incident.CreatorFunc
+	incidentResolver incident.ResolverFunc
+	entityLocator    services.EntityLocator
+	rules            *rules.RuleSet
+	categories       categories.Mapping
+	overrides        locations.Overrides
+	devices          *devices.Filter
+	locale           *locale.Catalog
+	descriptions     *descriptions.Templates
+	freeze           *seasons.Freeze
+	state            state.Store
+	locks            keyLocks
+	tenant           string
+	now              func() time.Time
 }
 
-func (c *cache) Add(key, value string) {
-	c.mx.Lock()
-	defer c.mx.Unlock()
-	c.items[key] = value
+type Option func(*app)
+
+func WithCategories(m categories.Mapping) Option {
+	return func(a *app) {
+		a.categories = m
+	}
 }
-func (c *cache) Equals(key, value string) bool {
-	c.mx.Lock()
-	defer c.mx.Unlock()
-	storedValue, ok := c.items[key]
-	if !ok {
-		return false
+
+func WithRules(rs *rules.RuleSet) Option {
+	return func(a *app) {
+		a.rules = rs
 	}
-	return storedValue == value
 }
-func (c *cache) ExistsAndIsChanged(key, value string) (bool, bool) {
-	c.mx.Lock()
-	defer c.mx.Unlock()
-	storedValue, ok := c.items[key]
-	if !ok {
-		return false, false
+
+// WithLocationOverrides sets the table of device locations used by rules with
+// the override location source
+func WithLocationOverrides(o locations.Overrides) Option {
+	return func(a *app) {
+		a.overrides = o
 	}
-	return true, storedValue != value
 }
 
-type app struct {
-	incidentReporter incident.ReporterFunc
-	entityLocator    services.EntityLocator
-	cache            cache
+// WithDeviceFilter sets the filter that decides which devices status messages
+// are accepted from, and the device class given to the rules
+func WithDeviceFilter(f *devices.Filter) Option {
+	return func(a *app) {
+		a.devices = f
+	}
+}
+
+// WithLocale sets the language that status messages are translated to, and
+// that the texts added to incident descriptions are written in
+func WithLocale(c *locale.Catalog) Option {
+	return func(a *app) {
+		a.locale = c
+	}
+}
+
+// WithDescriptions sets the templates used for the descriptions of incidents
+// of the kinds they cover, instead of the descriptions of the rules
+func WithDescriptions(t *descriptions.Templates) Option {
+	return func(a *app) {
+		a.descriptions = t
+	}
+}
+
+// WithFreezeSeason sets the season when freeze warnings are reported, which
+// is given to the rules as the freezeSeason property of status messages
+func WithFreezeSeason(f *seasons.Freeze) Option {
+	return func(a *app) {
+		a.freeze = f
+	}
 }
 
-func NewApplication(_ context.Context, incidentReporter incident.ReporterFunc, entityLocator services.EntityLocator) IntegrationIncident {
+// WithResolver enables closing of incidents for rules that have a resolve section
+func WithResolver(r incident.ResolverFunc) Option {
+	return func(a *app) {
+		a.incidentResolver = r
+	}
+}
+
+// WithTenant prefixes the dedup keys and marks the incidents with the tenant
+func WithTenant(tenant string) Option {
+	return func(a *app) {
+		a.tenant = tenant
+	}
+}
+
+// WithStateStore sets where the dedup state is kept. Unless set, the state is
+// kept in memory and lost on restart.
+func WithStateStore(s state.Store) Option {
+	return func(a *app) {
+		a.state = s
+	}
+}
+
+func NewApplication(_ context.Context, incidentReporter incident.CreatorFunc, entityLocator services.EntityLocator, opts ...Option) IntegrationIncident {
 
 	newApp := &app{
 		incidentReporter: incidentReporter,
 		entityLocator:    entityLocator,
-		cache:            cache{items: make(map[string]string)},
+		now:              func() time.Time { return time.Now().UTC() },
+	}
+
+	for _, opt := range opts {
+		opt(newApp)
+	}
+
+	if newApp.rules == nil {
+		newApp.rules = rules.Default()
+	}
+
+	if newApp.state == nil {
+		newApp.state = state.NewInMemoryStore()
+	}
+
+	if newApp.categories == nil {
+		newApp.categories = categories.Default()
+	}
+
+	if newApp.devices == nil {
+		newApp.devices = devices.Default()
+	}
+
+	if newApp.locale == nil {
+		newApp.locale, _ = locale.Default().Get(locale.DefaultLanguage)
+	}
+
+	if newApp.freeze == nil {
+		newApp.freeze = seasons.DefaultFreeze()
 	}
 
 	return newApp
@@ -78,22 +180,16 @@ func NewApplication(_ context.Context, incidentReporter incident.ReporterFunc, e
 func (a *app) DeviceStateUpdated(ctx context.Context, deviceId string, sm models.StatusMessage) error {
 	var err error
 
-	if !strings.Contains(deviceId, "se:servanet:lora:msva:") {
-		return fmt.Errorf("device with id %s is not supported", deviceId)
-	}
-
 	log := logging.GetFromContext(ctx)
 
 	ctx, span := tracer.Start(ctx, "device-state-updated")
 	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
 	_, ctx, log = o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
 
-	shortId := deviceId[strings.LastIndex(deviceId, ":")+1:]
-
-	const (
-		StateNoError string = "0"
-		PayloadError string = "100"
-	)
+	deviceClass, ok := a.devices.Classify(ctx, deviceId)
+	if !ok {
+		return nil
+	}
 
 	if sm.Code == nil {
 		b, _ := json.Marshal(sm)
@@ -101,152 +197,806 @@ func (a *app) DeviceStateUpdated(ctx context.Context, deviceId string, sm models
 		return nil
 	}
 
-	deviceState := *sm.Code
+	evt := rules.Event{
+		Source: rules.SourceStatusMessage,
+		ID:     deviceId,
+		Properties: map[string]string{
+			"deviceClass":  deviceClass,
+			"shortId":      deviceId[strings.LastIndex(deviceId, ":")+1:],
+			"statusCode":   *sm.Code,
+			"messages":     strings.Join(sm.Messages, " "),
+			"errorType":    Join(sm.Messages, " ", a.locale.Status),
+			"freezeSeason": strconv.FormatBool(a.freezeSeason(ctx, sm.Timestamp)),
+			"tenant":       sm.Tenant,
+			"timestamp":    sm.Timestamp.Format(time.RFC3339Nano),
+		},
+	}
 
-	if deviceState == PayloadError {
-		log.Warn("ignoring payload error")
-		return nil
+	err = a.evaluate(ctx, evt)
+
+	return err
+}
+
+func (a *app) LifebuoyValueUpdated(ctx context.Context, tenant, deviceId, deviceValue string) error {
+	var err error
+	var log *slog.Logger
+
+	ctx, span := tracer.Start(ctx, "lifebuoy-updated")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	_, ctx, log = o11y.AddTraceIDToLoggerAndStoreInContext(span, logging.GetFromContext(ctx), ctx)
+
+	const LifebuoyTypeName string = "Lifebuoy"
+
+	if deviceValue == "off" {
+		log.Info("state is \"off\" on device", "device_id", deviceId)
 	}
 
-	if deviceState == StateNoError {
-		return nil
+	evt := rules.Event{
+		Source: rules.SourceNotification,
+		Type:   LifebuoyTypeName,
+		ID:     deviceId,
+		Properties: map[string]string{
+			"shortId": strings.TrimPrefix(deviceId, "urn:ngsi-ld:"+LifebuoyTypeName+":"),
+			"status":  deviceValue,
+			"tenant":  tenant,
+		},
 	}
 
-	key := fmt.Sprintf("%s:%s", shortId, "state")
-	exists, changed := a.cache.ExistsAndIsChanged(key, deviceState)
+	if len(a.rules.Select(evt)) == 0 {
+		err = fmt.Errorf("device with id %s is not supported", deviceId)
+		return err
+	}
 
-	if exists && !changed {
-		log.Debug("device state has not changed", "device_id", deviceId, "state", deviceState)
+	err = a.evaluate(ctx, evt)
+
+	return err
+}
+
+// WeatherObserved keeps the latest temperature of the weather entity of the freeze season
+func (a *app) WeatherObserved(ctx context.Context, tenant, entityId string, temperature float64, observedAt time.Time) error {
+	var err error
+
+	ctx, span := tracer.Start(ctx, "weather-observed")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	log := logging.GetFromContext(ctx)
+	_, ctx, log = o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+	if a.freeze.Weather == nil || a.freeze.Weather.Entity != entityId {
+		log.Debug("ignoring weather observation", "entity_id", entityId, "tenant", tenant)
 		return nil
 	}
 
-	// log.Info("device state changed", "state", deviceState)
+	if observedAt.IsZero() {
+		observedAt = a.now()
+	}
 
-	const watermeterCategory int = 17
-	errorType := Join(sm.Messages, " ", translate)
+	key := a.stateKey("weather:" + entityId)
 
-	if !strings.Contains(errorType, "Spricka") && !strings.Contains(errorType, "Läckage") && !strings.Contains(errorType, "Is") {
-		log.Info(fmt.Sprintf("device state contains error of type '%s', which is not prioritised", errorType))
-		return nil
+	unlock := a.locks.lock(key)
+	defer unlock()
+
+	previous, exists, err := a.state.Get(ctx, key)
+	if err != nil {
+		err = fmt.Errorf("could not read state: %s", err.Error())
+		return err
 	}
 
-	if errorType == "Is eller Frys Varning" && !withinBounds(ctx, sm.Timestamp) {
-		log.Info("a freeze warning was received, but timestamp is out of bounds")
+	if exists && previous.Updated.After(observedAt) {
 		return nil
 	}
 
-	incident := models.NewIncident(watermeterCategory, translateJoin(deviceId, sm)).AtLocation(62.388178, 17.315090)
-
-	err = a.incidentReporter(ctx, *incident)
+	err = a.state.Set(ctx, key, state.Entry{Value: strconv.FormatFloat(temperature, 'f', -1, 64), Updated: observedAt})
 	if err != nil {
-		err = fmt.Errorf("could not post incident: %s", err.Error())
+		err = fmt.Errorf("could not store state: %s", err.Error())
 		return err
 	}
 
-	log.Debug("incident reported", "device_id", deviceId, "state", deviceState, "error_type", errorType)
+	log.Debug("weather observed", "entity_id", entityId, "temperature", temperature)
 
-	a.cache.Add(key, deviceState)
-	
 	return nil
 }
 
-func withinBounds(_ context.Context, timestamp time.Time) bool {
-	if timestamp.Month() > 4 && timestamp.Month() < 10 {
-		return false
+// freezeSeason reports whether freeze warnings are reported at the time of a
+// status message, or now if the message has no timestamp
+func (a *app) freezeSeason(ctx context.Context, t time.Time) bool {
+	if t.IsZero() {
+		t = a.now()
+	}
+
+	if a.freeze.Weather == nil {
+		return a.freeze.Active(t, nil)
+	}
+
+	var latest *seasons.Observation
+
+	entry, exists, err := a.state.Get(ctx, a.stateKey("weather:"+a.freeze.Weather.Entity))
+	if err != nil {
+		logging.GetFromContext(ctx).Error("could not read weather observation", "err", err.Error())
 	}
 
-	return true
+	if exists {
+		temperature, err := strconv.ParseFloat(entry.Value, 64)
+		if err == nil {
+			latest = &seasons.Observation{Temperature: temperature, ObservedAt: entry.Updated}
+		}
+	}
+
+	return a.freeze.Active(t, latest)
 }
 
-func (a *app) LifebuoyValueUpdated(ctx context.Context, deviceId, deviceValue string) error {
+func (a *app) SewageOverflowObserved(ctx context.Context, functionUpdated models.FunctionUpdated) error {
 	var err error
-	var log *slog.Logger
 
-	ctx, span := tracer.Start(ctx, "lifebuoy-updated")
+	ctx, span := tracer.Start(ctx, "sewage-overflow-observed")
 	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
 
-	_, ctx, log = o11y.AddTraceIDToLoggerAndStoreInContext(span, logging.GetFromContext(ctx), ctx)
+	log := logging.GetFromContext(ctx)
+	_, ctx, log = o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
 
-	const LifebuoyTypeName string = "Lifebuoy"
-	const LifebuoyIDPrefix string = "urn:ngsi-ld:" + LifebuoyTypeName + ":"
+	if functionUpdated.Stopwatch != nil && functionUpdated.Stopwatch.State {
+		log.Info(fmt.Sprintf("SewageOverflowObserved, id: %s, name: %s", functionUpdated.Id, functionUpdated.Name))
+	}
 
-	if !strings.HasPrefix(deviceId, LifebuoyIDPrefix) {
-		err = fmt.Errorf("device with id %s is not supported", deviceId)
-		return err
+	err = a.evaluate(ctx, functionEvent(functionUpdated))
+
+	return err
+}
+
+func (a *app) FunctionUpdated(ctx context.Context, functionUpdated models.FunctionUpdated) error {
+	var err error
+
+	ctx, span := tracer.Start(ctx, "function-updated")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	log := logging.GetFromContext(ctx)
+	_, ctx, log = o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+
+	if functionUpdated.Timer != nil && functionUpdated.Timer.State {
+		log.Debug("timer is running", "id", functionUpdated.Id, "name", functionUpdated.Name, "start_time", functionUpdated.Timer.StartTime)
+	}
+
+	err = a.evaluate(ctx, functionEvent(functionUpdated))
+
+	return err
+}
+
+func functionEvent(f models.FunctionUpdated) rules.Event {
+	evt := rules.Event{
+		Source:  rules.SourceFunctionUpdated,
+		Type:    f.Type,
+		SubType: f.SubType,
+		ID:      f.Id,
+		Properties: map[string]string{
+			"name":   f.Name,
+			"tenant": f.Tenant,
+		},
+	}
+
+	if f.Location != nil {
+		evt.Location = &rules.Location{Latitude: f.Location.Latitude, Longitude: f.Location.Longitude}
+	}
+
+	p := evt.Properties
+
+	if f.Stopwatch != nil {
+		p["stopwatch.state"] = strconv.FormatBool(f.Stopwatch.State)
+		p["stopwatch.count"] = strconv.Itoa(int(f.Stopwatch.Count))
+	}
+	if f.Counter != nil {
+		p["counter.count"] = strconv.Itoa(f.Counter.Counter)
+		p["counter.state"] = strconv.FormatBool(f.Counter.State)
+	}
+	if f.Level != nil {
+		p["level.current"] = strconv.FormatFloat(f.Level.Current, 'f', -1, 64)
+		if f.Level.Percent != nil {
+			p["level.percent"] = strconv.FormatFloat(*f.Level.Percent, 'f', -1, 64)
+		}
+		if f.Level.Offset != nil {
+			p["level.offset"] = strconv.FormatFloat(*f.Level.Offset, 'f', -1, 64)
+		}
+	}
+	if f.Presence != nil {
+		p["presence.state"] = strconv.FormatBool(f.Presence.State)
+	}
+	if f.Timer != nil {
+		p["timer.state"] = strconv.FormatBool(f.Timer.State)
+		p["timer.startTime"] = f.Timer.StartTime.Format(time.RFC3339Nano)
+		if f.Timer.EndTime != nil {
+			p["timer.endTime"] = f.Timer.EndTime.Format(time.RFC3339Nano)
+		}
+		if f.Timer.Duration != nil {
+			p["timer.duration"] = f.Timer.Duration.String()
+		}
+	}
+	if f.WaterQuality != nil {
+		p["waterquality.temperature"] = strconv.FormatFloat(f.WaterQuality.Temperature, 'f', -1, 64)
+		if !f.WaterQuality.Timestamp.IsZero() {
+			p["timestamp"] = f.WaterQuality.Timestamp.Format(time.RFC3339Nano)
+		}
+	}
+	if f.Building != nil {
+		p["building.energy"] = strconv.FormatFloat(f.Building.Energy, 'f', -1, 64)
+		p["building.power"] = strconv.FormatFloat(f.Building.Power, 'f', -1, 64)
 	}
 
-	shortId := strings.TrimPrefix(deviceId, LifebuoyIDPrefix)
+	return evt
+}
+
+// evaluate runs the event through every rule that accepts it
+func (a *app) evaluate(ctx context.Context, evt rules.Event) error {
+	log := logging.GetFromContext(ctx)
 
-	key := fmt.Sprintf("%s:%s", shortId, "value")
-	exists, changed := a.cache.ExistsAndIsChanged(key, deviceValue)
+	now := a.now()
 
-	if exists && !changed {
+	// events that carry no time of their own are given the time they arrived
+	if evt.Property("timestamp") == "" {
+		evt = evt.With("timestamp", now.Format(time.RFC3339Nano))
+	}
+
+	selected := a.rules.Select(evt)
+	if len(selected) == 0 {
+		log.Debug("no rule matched event", "source", evt.Source, "id", evt.ID)
 		return nil
 	}
 
-	if deviceValue == "off" {
-		log.Info("state changed to \"off\" on device", "device_id", shortId)
+	for _, r := range selected {
+		err := a.apply(ctx, r, evt, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-		const lifebuoyCategory int = 15
-		incident := models.NewIncident(lifebuoyCategory, "Livboj kan ha flyttats eller utsatts för åverkan.")
+// apply runs the event through a single rule while holding the lock for its
+// dedup key
+func (a *app) apply(ctx context.Context, r rules.Rule, evt rules.Event, now time.Time) error {
+	log := logging.GetFromContext(ctx)
 
-		latitude, longitude, err := a.entityLocator.Locate(ctx, LifebuoyTypeName, deviceId)
-		if err == nil {
-			incident = incident.AtLocation(latitude, longitude)
+	key := a.key(r, evt)
+
+	unlock := a.locks.lock(key)
+	defer unlock()
+
+	previous, exists, err := a.state.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("could not read state: %s", err.Error())
+	}
+
+	if exists && r.Dedup.TTL > 0 && now.Sub(previous.Updated) > r.Dedup.TTL {
+		log.Debug("stored state has expired", "rule", r.ID, "key", key)
+		exists = false
+	}
+
+	if r.Silence != nil && evt.Property("silent") != "true" {
+		if err := a.watch(ctx, r, evt, now); err != nil {
+			return err
 		}
+		evt = evt.With("silent", "false")
+	}
 
-		err = a.incidentReporter(ctx, *incident)
+	if r.Timer != nil {
+		if evt.Property("timer.state") == "true" {
+			err = a.watch(ctx, r, evt, now)
+		} else {
+			err = a.forget(ctx, r, evt, now)
+		}
 		if err != nil {
-			err = fmt.Errorf("could not post incident: %s", err.Error())
 			return err
 		}
+
+		var ok bool
+		evt, ok = r.Measure(evt, now)
+		if !ok {
+			log.Debug("event has no start time for the timer", "rule", r.ID, "key", key)
+			return nil
+		}
 	}
 
-	a.cache.Add(key, deviceValue)
+	if r.Window != nil {
+		v, err := strconv.ParseFloat(evt.Property(r.Window.Property), 64)
+		if err != nil {
+			log.Debug("event has no value for the window", "rule", r.ID, "key", key, "property", r.Window.Property)
+			return nil
+		}
+
+		count, err := a.window(ctx, r, evt, now, v)
+		if err != nil {
+			return err
+		}
+		evt = evt.With("window.count", strconv.FormatFloat(count, 'f', -1, 64))
+	}
+
+	if r.Baseline != nil {
+		v, err := strconv.ParseFloat(evt.Property(r.Baseline.Property), 64)
+		if err != nil {
+			log.Debug("event has no value for the baseline", "rule", r.ID, "key", key, "property", r.Baseline.Property)
+			return nil
+		}
+
+		mean, known, err := a.baseline(ctx, r, evt, now, v)
+		if err != nil {
+			return err
+		}
+		if !known || mean == 0 {
+			log.Debug("baseline is not known yet", "rule", r.ID, "key", key)
+			return nil
+		}
+
+		deviation := math.Round((v-mean)/mean*1000) / 10
+		evt = evt.
+			With("baseline", strconv.FormatFloat(mean, 'f', -1, 64)).
+			With("baseline.deviation", strconv.FormatFloat(deviation, 'f', -1, 64))
+	}
+
+	// the threshold of a rule depends on the stored value, as a crossed limit
+	// is kept until the value has moved back by the hysteresis
+	previousValue := ""
+	if exists {
+		previousValue = previous.Value
+	}
+
+	evt, ok := r.Classify(evt, previousValue)
+	if !ok {
+		log.Debug("event has no value for the threshold", "rule", r.ID, "key", key, "property", r.Threshold.Property)
+		return nil
+	}
+
+	value := r.Value(evt)
+
+	// a queued incident is reported again, as the queue is lost on restart
+	if exists && previous.Value == value && !previous.Queued {
+		if !reminderDue(r, previous, now) || !r.Triggered(evt) {
+			log.Debug("value has not changed", "rule", r.ID, "key", key, "value", value)
+			return nil
+		}
+
+		return a.remind(ctx, r, evt, key, previous, now)
+	}
+
+	entry := state.Entry{Value: value, Updated: now}
+
+	if r.Triggered(evt) {
+		var before *state.Entry
+		if exists {
+			before = &previous
+		}
+
+		incidentID, err := a.report(ctx, r, evt, key, before, 0)
+		if errors.Is(err, incident.ErrQueued) {
+			entry.Queued = true
+		} else if err != nil {
+			return err
+		} else {
+			entry.Reported = now
+		}
+		entry.Incidents = appendID(previous.Incidents, incidentID)
+	} else if len(previous.Incidents) > 0 {
+		// incidents are resolved even if the state has expired, since they
+		// would otherwise be left open for good
+		remaining, err := a.resolve(ctx, r, evt, previous.Incidents)
+		if err != nil {
+			// keep the previous value so that resolving is retried on the next event
+			previous.Incidents = remaining
+			if serr := a.state.Set(ctx, key, previous); serr != nil {
+				err = errors.Join(err, fmt.Errorf("could not store state: %s", serr.Error()))
+			}
+			return err
+		}
+		entry.Incidents = remaining
+	}
+
+	entry.Rule, entry.Event = stateEvent(r, evt)
+
+	err = a.state.Set(ctx, key, entry)
+	if err != nil {
+		return fmt.Errorf("could not store state: %s", err.Error())
+	}
 
 	return nil
 }
 
-func (a *app) SewageOverflowObserved(ctx context.Context, functionUpdated models.FunctionUpdated) error {
-	var err error
+// remind reports the incident of a lasting state again, with the number of
+// the reminder, and stores the event it was reported for
+func (a *app) remind(ctx context.Context, r rules.Rule, evt rules.Event, key string, previous state.Entry, now time.Time) error {
+	before := previous
+	previous.Reminders++
 
-	ctx, span := tracer.Start(ctx, "sewage-overflow-observed")
-	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+	incidentID, err := a.report(ctx, r, evt, key, &before, previous.Reminders)
+	if err != nil && !errors.Is(err, incident.ErrQueued) {
+		return err
+	}
 
-	log := logging.GetFromContext(ctx)
-	_, ctx, log = o11y.AddTraceIDToLoggerAndStoreInContext(span, log, ctx)
+	previous.Reported = now
+	previous.Incidents = appendID(previous.Incidents, incidentID)
+	previous.Rule, previous.Event = stateEvent(r, evt)
+
+	err = a.state.Set(ctx, key, previous)
+	if err != nil {
+		return fmt.Errorf("could not store state: %s", err.Error())
+	}
+
+	return nil
+}
+
+// stateEvent returns the rule and event to keep with a state, so that
+// reminders can be sent by Recheck and late incidents can be resolved
+func stateEvent(r rules.Rule, evt rules.Event) (string, json.RawMessage) {
+	if r.Reminder == nil && r.Resolve == nil {
+		return r.ID, nil
+	}
+
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return "", nil
+	}
+
+	return r.ID, b
+}
+
+// IncidentCreated records the id of an incident that was posted after it was reported
+func (a *app) IncidentCreated(ctx context.Context, key, incidentID string) error {
+	unlock := a.locks.lock(key)
+	defer unlock()
+
+	entry, exists, err := a.state.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("could not read state: %s", err.Error())
+	}
 
-	key := fmt.Sprintf("%s:%s:%s", functionUpdated.Id, functionUpdated.Type, functionUpdated.SubType)
+	if !exists {
+		return fmt.Errorf("no state for incident %s with key %s", incidentID, key)
+	}
+
+	if entry.Queued {
+		entry.Queued = false
+		entry.Reported = a.now()
+	} else if entry.Reported.IsZero() {
+		return a.resolveLate(ctx, key, entry, incidentID)
+	}
+
+	entry.Incidents = appendID(entry.Incidents, incidentID)
+
+	err = a.state.Set(ctx, key, entry)
+	if err != nil {
+		return fmt.Errorf("could not store state: %s", err.Error())
+	}
+
+	return nil
+}
 
-	if a.cache.Equals(key, strconv.FormatBool(functionUpdated.Stopwatch.State)) {
+// resolveLate resolves an incident that was posted after its state cleared,
+// with the event that cleared it
+func (a *app) resolveLate(ctx context.Context, key string, entry state.Entry, incidentID string) error {
+	if incidentID == "" {
 		return nil
 	}
 
-	if functionUpdated.Stopwatch.State {
-		const SewageOverflowObservedCategory int = 18
+	r, ok := a.rules.Find(entry.Rule)
+	if !ok {
+		return fmt.Errorf("no rule %s to resolve incident %s with key %s", entry.Rule, incidentID, key)
+	}
+
+	evt := rules.Event{}
+	if entry.Event != nil {
+		err := json.Unmarshal(entry.Event, &evt)
+		if err != nil {
+			return fmt.Errorf("could not read event for %s: %s", key, err.Error())
+		}
+	}
+
+	logging.GetFromContext(ctx).Info("resolving incident created after its state cleared", "rule", r.ID, "key", key, "incident_id", incidentID)
 
-		log.Info(fmt.Sprintf("SewageOverflowObserved, id: %s, name: %s", functionUpdated.Id, functionUpdated.Name))
+	_, err := a.resolve(ctx, r, evt, []string{incidentID})
+	return err
+}
+
+// Recheck evaluates silent sources and running timers again and sends the reminders that are due
+func (a *app) Recheck(ctx context.Context) error {
+	var errs []error
+
+	now := a.now()
+
+	due, err := a.due(ctx, now)
+	if err != nil {
+		errs = append(errs, err)
+	}
 
-		incident := models.NewIncident(SewageOverflowObservedCategory, fmt.Sprintf("Bräddning upptäckt vid %s", functionUpdated.Name))
+	for _, w := range due {
+		evt := w.evt.With("timestamp", now.Format(time.RFC3339Nano))
 
-		if functionUpdated.Location != nil {
-			incident = incident.AtLocation(functionUpdated.Location.Latitude, functionUpdated.Location.Longitude)
+		if w.rule.Silence != nil {
+			evt = evt.
+				With("silent", "true").
+				With("silence", now.Sub(w.at).Round(time.Minute).String()).
+				With("lastSeen", w.at.Format(time.RFC3339Nano))
 		}
 
-		err = a.incidentReporter(ctx, *incident)
+		if !w.rule.Accepts(evt) {
+			continue
+		}
+
+		err := a.apply(ctx, w.rule, evt, now)
 		if err != nil {
-			err = fmt.Errorf("could not post incident: %s", err.Error())
-			return err
+			errs = append(errs, err)
 		}
 	}
 
-	a.cache.Add(key, strconv.FormatBool(functionUpdated.Stopwatch.State))
+	err = a.state.Range(ctx, a.stateKey(""), func(key string, entry state.Entry) error {
+		if entry.Rule == "" || entry.Event == nil {
+			return nil
+		}
+
+		r, ok := a.rules.Find(entry.Rule)
+		if !ok || !reminderDue(r, entry, now) {
+			return nil
+		}
+
+		if err := a.remindLater(ctx, r, key, now); err != nil {
+			errs = append(errs, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// remindLater sends a reminder for a state if it is still due
+func (a *app) remindLater(ctx context.Context, r rules.Rule, key string, now time.Time) error {
+	unlock := a.locks.lock(key)
+	defer unlock()
+
+	entry, exists, err := a.state.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("could not read state: %s", err.Error())
+	}
+
+	if !exists || !reminderDue(r, entry, now) {
+		return nil
+	}
+
+	if r.Dedup.TTL > 0 && now.Sub(entry.Updated) > r.Dedup.TTL {
+		return nil
+	}
+
+	evt := rules.Event{}
+	err = json.Unmarshal(entry.Event, &evt)
+	if err != nil {
+		return fmt.Errorf("could not read reported event for %s: %s", key, err.Error())
+	}
+
+	return a.remind(ctx, r, evt, key, entry, now)
+}
+
+func (a *app) key(r rules.Rule, evt rules.Event) string {
+	return a.stateKey(r.Key(evt))
+}
+
+func (a *app) stateKey(key string) string {
+	if a.tenant == "" {
+		return key
+	}
+	return a.tenant + "/" + key
+}
+
+func reminderDue(r rules.Rule, previous state.Entry, now time.Time) bool {
+	if r.Reminder == nil || previous.Reported.IsZero() {
+		return false
+	}
+
+	if r.Reminder.Limit > 0 && previous.Reminders >= r.Reminder.Limit {
+		return false
+	}
+
+	return now.Sub(previous.Reported) >= r.Reminder.After
+}
+
+// report posts an incident for the rule. Rejected incidents are logged and treated as reported.
+func (a *app) report(ctx context.Context, r rules.Rule, evt rules.Event, key string, previous *state.Entry, reminder int) (string, error) {
+	log := logging.GetFromContext(ctx)
+
+	i := models.NewIncident(a.category(r), "")
+	i.Key = key
+	i.Tenant = a.tenant
+	location := a.locate(ctx, r, evt, i)
+
+	i.Description = a.describe(ctx, r, evt, location, previous, reminder)
+	if reminder > 0 {
+		i.Description = a.locale.Textf("reminder", i.Description, reminder)
+	}
+
+	incidentID, err := a.incidentReporter(ctx, *i)
+	if errors.Is(err, incident.ErrQueued) {
+		log.Debug("incident queued", "rule", r.ID, "id", evt.ID)
+		return "", err
+	}
+	if err != nil {
+		if incident.IsPermanent(err) {
+			log.Error("incident was rejected and will not be posted again", "rule", r.ID, "id", evt.ID, "err", err.Error())
+			return "", nil
+		}
+		return "", fmt.Errorf("could not post incident: %w", err)
+	}
+
+	log.Debug("incident reported", "rule", r.ID, "id", evt.ID, "incident_id", incidentID)
+
+	return incidentID, nil
+}
+
+// resolve closes the open incidents of a rule and returns those that are still open
+func (a *app) resolve(ctx context.Context, r rules.Rule, evt rules.Event, incidents []string) ([]string, error) {
+	if r.Resolve == nil || a.incidentResolver == nil {
+		return nil, nil
+	}
+
+	log := logging.GetFromContext(ctx)
+	note := r.ResolutionNote(evt, a.locale.Text)
+
+	for i, incidentID := range incidents {
+		err := a.incidentResolver(ctx, incidentID, note)
+		if err != nil {
+			if incident.IsPermanent(err) {
+				log.Error("incident could not be resolved and is forgotten", "rule", r.ID, "id", evt.ID, "incident_id", incidentID, "err", err.Error())
+				continue
+			}
+			return incidents[i:], fmt.Errorf("could not resolve incident %s: %w", incidentID, err)
+		}
+
+		log.Info("incident resolved", "rule", r.ID, "id", evt.ID, "incident_id", incidentID)
+	}
+
+	return nil, nil
+}
+
+func appendID(ids []string, id string) []string {
+	if id == "" {
+		return ids
+	}
+	return append(ids, id)
+}
+
+// keyLocks serialises the updates of the state for each dedup key
+type keyLocks struct {
+	mx    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func (k *keyLocks) lock(key string) func() {
+	k.mx.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*sync.Mutex{}
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		k.locks[key] = l
+	}
+	k.mx.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func (a *app) category(r rules.Rule) int {
+	if id, ok := a.categories.Category(r.Kind); ok {
+		return id
+	}
+	return r.Incident.Category
+}
+
+// describe renders the template for the kind of the rule, or falls back to the rule
+func (a *app) describe(ctx context.Context, r rules.Rule, evt rules.Event, location *descriptions.Location, previous *state.Entry, reminder int) string {
+	data := descriptions.Data{
+		Kind: r.Kind,
+		Rule: r.ID,
+		Time: a.eventTime(evt),
+		Device: descriptions.Device{
+			ID:      evt.ID,
+			ShortID: evt.Property("shortId"),
+			Name:    evt.Property("name"),
+			Type:    evt.Type,
+			SubType: evt.SubType,
+			Class:   evt.Property("deviceClass"),
+			Tenant:  evt.Property("tenant"),
+		},
+		Location: location,
+		Event:    evt.Properties,
+		Reminder: reminder,
+	}
+
+	if previous != nil {
+		data.Previous = &descriptions.Previous{
+			Value:     previous.Value,
+			Updated:   previous.Updated,
+			Reported:  previous.Reported,
+			Reminders: previous.Reminders,
+			Incidents: previous.Incidents,
+		}
+	}
+
+	description, ok, err := a.descriptions.Render(data)
+	if err != nil {
+		logging.GetFromContext(ctx).Error("failed to render description template", "kind", r.Kind, "rule", r.ID, "err", err.Error())
+	}
+
+	if !ok || err != nil {
+		return r.Description(evt, a.locale.Text)
+	}
+
+	return description
+}
+
+// eventTime returns the time given by the timestamp of the event, or now if
+// the event has no timestamp that can be parsed
+func (a *app) eventTime(evt rules.Event) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, evt.Property("timestamp"))
+	if err != nil {
+		return a.now()
+	}
+	return t
+}
+
+// locate places the incident at the first location found by the sources of the rule
+func (a *app) locate(ctx context.Context, r rules.Rule, evt rules.Event, incident *models.Incident) *descriptions.Location {
+	log := logging.GetFromContext(ctx)
+	setting := r.Incident.Location
+
+	for _, src := range setting.Sources() {
+		latitude, longitude, ok := a.locateFrom(ctx, src, setting, evt)
+		if !ok {
+			continue
+		}
+
+		incident.AtLocation(latitude, longitude)
+
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("location_source", src))
+		log.Debug("incident located", "id", evt.ID, "location_source", src)
+		return &descriptions.Location{Latitude: latitude, Longitude: longitude, Source: src}
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("location_source", rules.LocationNone))
+	log.Debug("no location found for incident", "id", evt.ID)
 
 	return nil
 }
 
-func translateJoin(deviceID string, sm models.StatusMessage) string {
-	return fmt.Sprintf("%s - %s", deviceID, Join(sm.Messages, " ", translate))
+func (a *app) locateFrom(ctx context.Context, src string, setting rules.LocationSetting, evt rules.Event) (float64, float64, bool) {
+	switch src {
+	case rules.LocationFixed:
+		return setting.Latitude, setting.Longitude, true
+	case rules.LocationEvent:
+		if evt.Location != nil {
+			return evt.Location.Latitude, evt.Location.Longitude, true
+		}
+	case rules.LocationOverride:
+		if p, ok := a.overrides.Lookup(evt.ID, evt.Property("shortId")); ok {
+			return p.Latitude, p.Longitude, true
+		}
+	case rules.LocationEntity:
+		entityType, entityID := evt.Type, evt.ID
+		if setting.EntityType != "" {
+			entityType = setting.EntityType
+			if !strings.HasPrefix(entityID, "urn:ngsi-ld:") {
+				entityID = "urn:ngsi-ld:" + entityType + ":" + entityID
+			}
+		}
+
+		latitude, longitude, err := a.entityLocator.Locate(ctx, entityType, entityID)
+		if err == nil {
+			return latitude, longitude, true
+		}
+
+		logging.GetFromContext(ctx).Debug("failed to locate entity", "entity_id", entityID, "err", err.Error())
+	}
+
+	return 0, 0, false
 }
 
 func Join(elems []string, sep string, mod func(string) string) string {
@@ -270,29 +1020,3 @@ func Join(elems []string, sep string, mod func(string) string) string {
 	}
 	return b.String()
 }
-
-func translate(s string) string {
-	switch s {
-	case "No error":
-		return "Inga fel"
-	case "Power low":
-		return "Låg batterinivå"
-	case "Permanent error":
-		return "Permanent fel"
-	case "Temporary error":
-		return "Temporärt fel"
-	case "Empty spool":
-		return "Tomt rör"
-	case "Leak":
-		return "Läckage"
-	case "Burst":
-		return "Spricka"
-	case "Backflow":
-		return "Backflöde"
-	case "Freeze":
-		return "Is eller Frys Varning"
-	case "Unknown":
-		return "Okänt fel"
-	}
-	return s
-}
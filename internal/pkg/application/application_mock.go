@@ -5,8 +5,9 @@ package application
 
 import (
 	"context"
-	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/models"
+	"github.com/diwise/integration-incident/pkg/models"
 	"sync"
+	"time"
 )
 
 // Ensure, that IntegrationIncidentMock does implement IntegrationIncident.
@@ -22,12 +23,24 @@ var _ IntegrationIncident = &IntegrationIncidentMock{}
 //			DeviceStateUpdatedFunc: func(ctx context.Context, deviceId string, statusMessage models.StatusMessage) error {
 //				panic("mock out the DeviceStateUpdated method")
 //			},
-//			LifebuoyValueUpdatedFunc: func(ctx context.Context, deviceId string, deviceValue string) error {
+//			FunctionUpdatedFunc: func(ctx context.Context, functionUpdated models.FunctionUpdated) error {
+//				panic("mock out the FunctionUpdated method")
+//			},
+//			IncidentCreatedFunc: func(ctx context.Context, key string, incidentID string) error {
+//				panic("mock out the IncidentCreated method")
+//			},
+//			LifebuoyValueUpdatedFunc: func(ctx context.Context, tenant string, deviceId string, deviceValue string) error {
 //				panic("mock out the LifebuoyValueUpdated method")
 //			},
+//			RecheckFunc: func(ctx context.Context) error {
+//				panic("mock out the Recheck method")
+//			},
 //			SewageOverflowObservedFunc: func(ctx context.Context, functionUpdated models.FunctionUpdated) error {
 //				panic("mock out the SewageOverflowObserved method")
 //			},
+//			WeatherObservedFunc: func(ctx context.Context, tenant string, entityId string, temperature float64, observedAt time.Time) error {
+//				panic("mock out the WeatherObserved method")
+//			},
 //		}
 //
 //		// use mockedIntegrationIncident in code that requires IntegrationIncident
@@ -38,12 +51,24 @@ type IntegrationIncidentMock struct {
 	// DeviceStateUpdatedFunc mocks the DeviceStateUpdated method.
 	DeviceStateUpdatedFunc func(ctx context.Context, deviceId string, statusMessage models.StatusMessage) error
 
+	// FunctionUpdatedFunc mocks the FunctionUpdated method.
+	FunctionUpdatedFunc func(ctx context.Context, functionUpdated models.FunctionUpdated) error
+
+	// IncidentCreatedFunc mocks the IncidentCreated method.
+	IncidentCreatedFunc func(ctx context.Context, key string, incidentID string) error
+
 	// LifebuoyValueUpdatedFunc mocks the LifebuoyValueUpdated method.
-	LifebuoyValueUpdatedFunc func(ctx context.Context, deviceId string, deviceValue string) error
+	LifebuoyValueUpdatedFunc func(ctx context.Context, tenant string, deviceId string, deviceValue string) error
+
+	// RecheckFunc mocks the Recheck method.
+	RecheckFunc func(ctx context.Context) error
 
 	// SewageOverflowObservedFunc mocks the SewageOverflowObserved method.
 	SewageOverflowObservedFunc func(ctx context.Context, functionUpdated models.FunctionUpdated) error
 
+	// WeatherObservedFunc mocks the WeatherObserved method.
+	WeatherObservedFunc func(ctx context.Context, tenant string, entityId string, temperature float64, observedAt time.Time) error
+
 	// calls tracks calls to the methods.
 	calls struct {
 		// DeviceStateUpdated holds details about calls to the DeviceStateUpdated method.
@@ -55,15 +80,38 @@ type IntegrationIncidentMock struct {
 			// StatusMessage is the statusMessage argument value.
 			StatusMessage models.StatusMessage
 		}
+		// FunctionUpdated holds details about calls to the FunctionUpdated method.
+		FunctionUpdated []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// FunctionUpdated is the functionUpdated argument value.
+			FunctionUpdated models.FunctionUpdated
+		}
+		// IncidentCreated holds details about calls to the IncidentCreated method.
+		IncidentCreated []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Key is the key argument value.
+			Key string
+			// IncidentID is the incidentID argument value.
+			IncidentID string
+		}
 		// LifebuoyValueUpdated holds details about calls to the LifebuoyValueUpdated method.
 		LifebuoyValueUpdated []struct {
 			// Ctx is the ctx argument value.
 			Ctx context.Context
+			// Tenant is the tenant argument value.
+			Tenant string
 			// DeviceId is the deviceId argument value.
 			DeviceId string
 			// DeviceValue is the deviceValue argument value.
 			DeviceValue string
 		}
+		// Recheck holds details about calls to the Recheck method.
+		Recheck []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+		}
 		// SewageOverflowObserved holds details about calls to the SewageOverflowObserved method.
 		SewageOverflowObserved []struct {
 			// Ctx is the ctx argument value.
@@ -71,10 +119,27 @@ type IntegrationIncidentMock struct {
 			// FunctionUpdated is the functionUpdated argument value.
 			FunctionUpdated models.FunctionUpdated
 		}
+		// WeatherObserved holds details about calls to the WeatherObserved method.
+		WeatherObserved []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Tenant is the tenant argument value.
+			Tenant string
+			// EntityId is the entityId argument value.
+			EntityId string
+			// Temperature is the temperature argument value.
+			Temperature float64
+			// ObservedAt is the observedAt argument value.
+			ObservedAt time.Time
+		}
 	}
 	lockDeviceStateUpdated     sync.RWMutex
+	lockFunctionUpdated        sync.RWMutex
+	lockIncidentCreated        sync.RWMutex
 	lockLifebuoyValueUpdated   sync.RWMutex
+	lockRecheck                sync.RWMutex
 	lockSewageOverflowObserved sync.RWMutex
+	lockWeatherObserved        sync.RWMutex
 }
 
 // DeviceStateUpdated calls DeviceStateUpdatedFunc.
@@ -117,24 +182,102 @@ func (mock *IntegrationIncidentMock) DeviceStateUpdatedCalls() []struct {
 	return calls
 }
 
+// FunctionUpdated calls FunctionUpdatedFunc.
+func (mock *IntegrationIncidentMock) FunctionUpdated(ctx context.Context, functionUpdated models.FunctionUpdated) error {
+	if mock.FunctionUpdatedFunc == nil {
+		panic("IntegrationIncidentMock.FunctionUpdatedFunc: method is nil but IntegrationIncident.FunctionUpdated was just called")
+	}
+	callInfo := struct {
+		Ctx             context.Context
+		FunctionUpdated models.FunctionUpdated
+	}{
+		Ctx:             ctx,
+		FunctionUpdated: functionUpdated,
+	}
+	mock.lockFunctionUpdated.Lock()
+	mock.calls.FunctionUpdated = append(mock.calls.FunctionUpdated, callInfo)
+	mock.lockFunctionUpdated.Unlock()
+	return mock.FunctionUpdatedFunc(ctx, functionUpdated)
+}
+
+// FunctionUpdatedCalls gets all the calls that were made to FunctionUpdated.
+// Check the length with:
+//
+//	len(mockedIntegrationIncident.FunctionUpdatedCalls())
+func (mock *IntegrationIncidentMock) FunctionUpdatedCalls() []struct {
+	Ctx             context.Context
+	FunctionUpdated models.FunctionUpdated
+} {
+	var calls []struct {
+		Ctx             context.Context
+		FunctionUpdated models.FunctionUpdated
+	}
+	mock.lockFunctionUpdated.RLock()
+	calls = mock.calls.FunctionUpdated
+	mock.lockFunctionUpdated.RUnlock()
+	return calls
+}
+
+// IncidentCreated calls IncidentCreatedFunc.
+func (mock *IntegrationIncidentMock) IncidentCreated(ctx context.Context, key string, incidentID string) error {
+	if mock.IncidentCreatedFunc == nil {
+		panic("IntegrationIncidentMock.IncidentCreatedFunc: method is nil but IntegrationIncident.IncidentCreated was just called")
+	}
+	callInfo := struct {
+		Ctx        context.Context
+		Key        string
+		IncidentID string
+	}{
+		Ctx:        ctx,
+		Key:        key,
+		IncidentID: incidentID,
+	}
+	mock.lockIncidentCreated.Lock()
+	mock.calls.IncidentCreated = append(mock.calls.IncidentCreated, callInfo)
+	mock.lockIncidentCreated.Unlock()
+	return mock.IncidentCreatedFunc(ctx, key, incidentID)
+}
+
+// IncidentCreatedCalls gets all the calls that were made to IncidentCreated.
+// Check the length with:
+//
+//	len(mockedIntegrationIncident.IncidentCreatedCalls())
+func (mock *IntegrationIncidentMock) IncidentCreatedCalls() []struct {
+	Ctx        context.Context
+	Key        string
+	IncidentID string
+} {
+	var calls []struct {
+		Ctx        context.Context
+		Key        string
+		IncidentID string
+	}
+	mock.lockIncidentCreated.RLock()
+	calls = mock.calls.IncidentCreated
+	mock.lockIncidentCreated.RUnlock()
+	return calls
+}
+
 // LifebuoyValueUpdated calls LifebuoyValueUpdatedFunc.
-func (mock *IntegrationIncidentMock) LifebuoyValueUpdated(ctx context.Context, deviceId string, deviceValue string) error {
+func (mock *IntegrationIncidentMock) LifebuoyValueUpdated(ctx context.Context, tenant string, deviceId string, deviceValue string) error {
 	if mock.LifebuoyValueUpdatedFunc == nil {
 		panic("IntegrationIncidentMock.LifebuoyValueUpdatedFunc: method is nil but IntegrationIncident.LifebuoyValueUpdated was just called")
 	}
 	callInfo := struct {
 		Ctx         context.Context
+		Tenant      string
 		DeviceId    string
 		DeviceValue string
 	}{
 		Ctx:         ctx,
+		Tenant:      tenant,
 		DeviceId:    deviceId,
 		DeviceValue: deviceValue,
 	}
 	mock.lockLifebuoyValueUpdated.Lock()
 	mock.calls.LifebuoyValueUpdated = append(mock.calls.LifebuoyValueUpdated, callInfo)
 	mock.lockLifebuoyValueUpdated.Unlock()
-	return mock.LifebuoyValueUpdatedFunc(ctx, deviceId, deviceValue)
+	return mock.LifebuoyValueUpdatedFunc(ctx, tenant, deviceId, deviceValue)
 }
 
 // LifebuoyValueUpdatedCalls gets all the calls that were made to LifebuoyValueUpdated.
@@ -143,11 +286,13 @@ func (mock *IntegrationIncidentMock) LifebuoyValueUpdated(ctx context.Context, d
 //	len(mockedIntegrationIncident.LifebuoyValueUpdatedCalls())
 func (mock *IntegrationIncidentMock) LifebuoyValueUpdatedCalls() []struct {
 	Ctx         context.Context
+	Tenant      string
 	DeviceId    string
 	DeviceValue string
 } {
 	var calls []struct {
 		Ctx         context.Context
+		Tenant      string
 		DeviceId    string
 		DeviceValue string
 	}
@@ -157,6 +302,38 @@ func (mock *IntegrationIncidentMock) LifebuoyValueUpdatedCalls() []struct {
 	return calls
 }
 
+// Recheck calls RecheckFunc.
+func (mock *IntegrationIncidentMock) Recheck(ctx context.Context) error {
+	if mock.RecheckFunc == nil {
+		panic("IntegrationIncidentMock.RecheckFunc: method is nil but IntegrationIncident.Recheck was just called")
+	}
+	callInfo := struct {
+		Ctx context.Context
+	}{
+		Ctx: ctx,
+	}
+	mock.lockRecheck.Lock()
+	mock.calls.Recheck = append(mock.calls.Recheck, callInfo)
+	mock.lockRecheck.Unlock()
+	return mock.RecheckFunc(ctx)
+}
+
+// RecheckCalls gets all the calls that were made to Recheck.
+// Check the length with:
+//
+//	len(mockedIntegrationIncident.RecheckCalls())
+func (mock *IntegrationIncidentMock) RecheckCalls() []struct {
+	Ctx context.Context
+} {
+	var calls []struct {
+		Ctx context.Context
+	}
+	mock.lockRecheck.RLock()
+	calls = mock.calls.Recheck
+	mock.lockRecheck.RUnlock()
+	return calls
+}
+
 // SewageOverflowObserved calls SewageOverflowObservedFunc.
 func (mock *IntegrationIncidentMock) SewageOverflowObserved(ctx context.Context, functionUpdated models.FunctionUpdated) error {
 	if mock.SewageOverflowObservedFunc == nil {
@@ -192,3 +369,51 @@ func (mock *IntegrationIncidentMock) SewageOverflowObservedCalls() []struct {
 	mock.lockSewageOverflowObserved.RUnlock()
 	return calls
 }
+
+// WeatherObserved calls WeatherObservedFunc.
+func (mock *IntegrationIncidentMock) WeatherObserved(ctx context.Context, tenant string, entityId string, temperature float64, observedAt time.Time) error {
+	if mock.WeatherObservedFunc == nil {
+		panic("IntegrationIncidentMock.WeatherObservedFunc: method is nil but IntegrationIncident.WeatherObserved was just called")
+	}
+	callInfo := struct {
+		Ctx         context.Context
+		Tenant      string
+		EntityId    string
+		Temperature float64
+		ObservedAt  time.Time
+	}{
+		Ctx:         ctx,
+		Tenant:      tenant,
+		EntityId:    entityId,
+		Temperature: temperature,
+		ObservedAt:  observedAt,
+	}
+	mock.lockWeatherObserved.Lock()
+	mock.calls.WeatherObserved = append(mock.calls.WeatherObserved, callInfo)
+	mock.lockWeatherObserved.Unlock()
+	return mock.WeatherObservedFunc(ctx, tenant, entityId, temperature, observedAt)
+}
+
+// WeatherObservedCalls gets all the calls that were made to WeatherObserved.
+// Check the length with:
+//
+//	len(mockedIntegrationIncident.WeatherObservedCalls())
+func (mock *IntegrationIncidentMock) WeatherObservedCalls() []struct {
+	Ctx         context.Context
+	Tenant      string
+	EntityId    string
+	Temperature float64
+	ObservedAt  time.Time
+} {
+	var calls []struct {
+		Ctx         context.Context
+		Tenant      string
+		EntityId    string
+		Temperature float64
+		ObservedAt  time.Time
+	}
+	mock.lockWeatherObserved.RLock()
+	calls = mock.calls.WeatherObserved
+	mock.lockWeatherObserved.RUnlock()
+	return calls
+}
@@ -2,12 +2,26 @@ package application
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
 
+	"github.com/diwise/integration-incident/internal/pkg/application/descriptions"
+	"github.com/diwise/integration-incident/internal/pkg/application/devices"
+	"github.com/diwise/integration-incident/internal/pkg/application/locale"
+	"github.com/diwise/integration-incident/internal/pkg/application/locations"
+	"github.com/diwise/integration-incident/internal/pkg/application/rules"
+	"github.com/diwise/integration-incident/internal/pkg/application/seasons"
 	"github.com/diwise/integration-incident/internal/pkg/application/services"
-	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/models"
+	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/state"
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
 	"github.com/matryer/is"
 )
 
@@ -52,6 +66,51 @@ func TestThatDeviceStateUpdatedSendIncidentIfStatusIsFreezeAndWithinBounds(t *te
 	incRep.assertCalledOnce(is)
 }
 
+func TestThatFreezeSeasonStartsAtMidnightLocalTime(t *testing.T) {
+	is, incRep, app := testSetup(t)
+
+	// 22:30 UTC on the 30th of April is already May in Stockholm
+	err := app.DeviceStateUpdated(context.Background(), "urn:ngsi-ld:Device:se:servanet:lora:msva:devId1", status("urn:ngsi-ld:Device:se:servanet:lora:msva:devId1", 1, time.Date(2024, 4, 30, 22, 30, 0, 0, time.UTC), "Freeze"))
+	is.NoErr(err)
+	incRep.assertNotCalled(is)
+
+	// and 22:30 UTC on the 30th of September is already October
+	err = app.DeviceStateUpdated(context.Background(), "urn:ngsi-ld:Device:se:servanet:lora:msva:devId2", status("urn:ngsi-ld:Device:se:servanet:lora:msva:devId2", 1, time.Date(2024, 9, 30, 22, 30, 0, 0, time.UTC), "Freeze"))
+	is.NoErr(err)
+	incRep.assertCalledOnce(is)
+}
+
+func TestThatFreezeWarningIsSentOutsideOfSeasonWhenItIsCold(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	incRep := newIncidentReporterThatReturns(nil)
+
+	freeze, err := seasons.ParseFreeze([]byte(`{from: "10-01", to: "04-30", weather: {entity: "urn:ngsi-ld:WeatherObserved:kiruna", below: 0}}`))
+	is.NoErr(err)
+
+	locator := &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.1, 17.1, nil
+		},
+	}
+	app := NewApplication(ctx, incRep.f, locator, WithFreezeSeason(freeze))
+
+	may := time.Date(2024, 5, 20, 4, 0, 0, 0, time.UTC)
+
+	is.NoErr(app.WeatherObserved(ctx, "default", "urn:ngsi-ld:WeatherObserved:kiruna", -3, may.Add(-time.Hour)))
+	is.NoErr(app.WeatherObserved(ctx, "default", "urn:ngsi-ld:WeatherObserved:elsewhere", 12, may))
+
+	err = app.DeviceStateUpdated(ctx, "se:servanet:lora:msva:devId1", status("se:servanet:lora:msva:devId1", 1, may, "Freeze"))
+	is.NoErr(err)
+	incRep.assertCalledOnce(is)
+
+	is.NoErr(app.WeatherObserved(ctx, "default", "urn:ngsi-ld:WeatherObserved:kiruna", 5, may))
+
+	err = app.DeviceStateUpdated(ctx, "se:servanet:lora:msva:devId2", status("se:servanet:lora:msva:devId2", 1, may, "Freeze"))
+	is.NoErr(err)
+	incRep.assertCalledOnce(is)
+}
+
 func TestThatDeviceStateUpdatedDoesNotSendIncidentIfDeviceDoesNotExist(t *testing.T) {
 	is, incRep, app := testSetup(t)
 
@@ -106,14 +165,106 @@ func TestThatDeviceUpdatedDoesNotSendIncidentReportOnUnknownState(t *testing.T)
 	incRep.assertCallCount(is, 0)
 }
 
+func TestThatWatermetersAreAcceptedByTheDeviceFilter(t *testing.T) {
+	is, incRep, _ := testSetup(t)
+
+	filter, err := devices.Parse([]byte(`
+classes:
+  - name: watermeter
+    include:
+      - prefix: "urn:ngsi-ld:Device:kamstrup:"
+`))
+	is.NoErr(err)
+
+	app := NewApplication(context.Background(), incRep.f, &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.1, 17.1, nil
+		},
+	}, WithDeviceFilter(filter))
+
+	err = app.DeviceStateUpdated(context.Background(), "urn:ngsi-ld:Device:se:servanet:lora:msva:devId1", status("urn:ngsi-ld:Device:se:servanet:lora:msva:devId1", 1, time.Now().UTC(), "Burst"))
+	is.NoErr(err)
+	incRep.assertNotCalled(is)
+
+	err = app.DeviceStateUpdated(context.Background(), "urn:ngsi-ld:Device:kamstrup:devId1", status("urn:ngsi-ld:Device:kamstrup:devId1", 1, time.Now().UTC(), "Burst"))
+	is.NoErr(err)
+	incRep.assertCalledOnce(is)
+}
+
+func TestThatStatusMessagesAreTranslatedToTheSelectedLanguage(t *testing.T) {
+	is, incRep, _ := testSetup(t)
+
+	en, err := locale.Default().Get("en")
+	is.NoErr(err)
+
+	app := NewApplication(context.Background(), incRep.f, &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.1, 17.1, nil
+		},
+	}, WithLocale(en))
+
+	err = app.DeviceStateUpdated(context.Background(), "se:servanet:lora:msva:devId1", status("se:servanet:lora:msva:devId1", 1, time.Now().UTC(), "Leak"))
+	is.NoErr(err)
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "se:servanet:lora:msva:devId1 - Leak")
+
+	err = app.DeviceStateUpdated(context.Background(), "se:servanet:lora:msva:devId2", status("se:servanet:lora:msva:devId2", 1, time.Date(2024, 2, 28, 12, 12, 12, 0, time.UTC), "Freeze"))
+	is.NoErr(err)
+	incRep.assertCallCount(is, 2)
+	is.Equal(incRep.incidents[1].Description, "se:servanet:lora:msva:devId2 - Ice or freeze warning")
+}
+
+func TestThatWatermeterIncidentIsLocatedThroughTheBroker(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+	locator := &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.1, 17.1, nil
+		},
+	}
+
+	app := NewApplication(context.Background(), incRep.f, locator)
+
+	err := app.DeviceStateUpdated(context.Background(), "se:servanet:lora:msva:devId1", status("se:servanet:lora:msva:devId1", 1, time.Now().UTC(), "Burst"))
+	is.NoErr(err)
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].MapCoordinates, "62.100000,17.100000")
+
+	is.Equal(locator.LocateCalls()[0].EntityType, "Device")
+	is.Equal(locator.LocateCalls()[0].EntityID, "urn:ngsi-ld:Device:se:servanet:lora:msva:devId1")
+}
+
+func TestThatWatermeterLocationFallsBackToOverridesAndThenDefault(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+	locator := &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 0, 0, errors.New("not found")
+		},
+	}
+
+	overrides := locations.Overrides{"devId1": {Latitude: 62.2, Longitude: 17.2}}
+	app := NewApplication(context.Background(), incRep.f, locator, WithLocationOverrides(overrides))
+
+	err := app.DeviceStateUpdated(context.Background(), "urn:ngsi-ld:Device:se:servanet:lora:msva:devId1", status("urn:ngsi-ld:Device:se:servanet:lora:msva:devId1", 1, time.Now().UTC(), "Burst"))
+	is.NoErr(err)
+
+	err = app.DeviceStateUpdated(context.Background(), "urn:ngsi-ld:Device:se:servanet:lora:msva:devId2", status("urn:ngsi-ld:Device:se:servanet:lora:msva:devId2", 1, time.Now().UTC(), "Burst"))
+	is.NoErr(err)
+
+	incRep.assertCallCount(is, 2)
+	is.Equal(incRep.incidents[0].MapCoordinates, "62.200000,17.200000")
+	is.Equal(incRep.incidents[1].MapCoordinates, "62.388178,17.315090")
+}
+
 func TestThatDeviceValueUpdatedDoesNotSendIncidentIfDeviceValueIsTheSame(t *testing.T) {
 	is, incRep, app := testSetup(t)
 
-	err := app.LifebuoyValueUpdated(context.Background(), "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "on")
+	err := app.LifebuoyValueUpdated(context.Background(), "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "on")
 	is.NoErr(err)
 	incRep.assertNotCalled(is)
 
-	err = app.LifebuoyValueUpdated(context.Background(), "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "on")
+	err = app.LifebuoyValueUpdated(context.Background(), "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "on")
 	is.NoErr(err)
 	incRep.assertNotCalled(is)
 }
@@ -121,16 +272,1124 @@ func TestThatDeviceValueUpdatedDoesNotSendIncidentIfDeviceValueIsTheSame(t *test
 func TestThatDeviceValueUpdatedSendsIncidentReportOnValueChanged(t *testing.T) {
 	is, incRep, app := testSetup(t)
 
-	err := app.LifebuoyValueUpdated(context.Background(), "urn:ngsi-ld:Lifebuoy:se:servanet:lora:sn-elt-livboj-02", "on")
+	err := app.LifebuoyValueUpdated(context.Background(), "default", "urn:ngsi-ld:Lifebuoy:se:servanet:lora:sn-elt-livboj-02", "on")
 	is.NoErr(err)
 
-	err = app.LifebuoyValueUpdated(context.Background(), "urn:ngsi-ld:Lifebuoy:se:servanet:lora:sn-elt-livboj-02", "off")
+	err = app.LifebuoyValueUpdated(context.Background(), "default", "urn:ngsi-ld:Lifebuoy:se:servanet:lora:sn-elt-livboj-02", "off")
 	is.NoErr(err)
 	incRep.assertCalledOnce(is)
 	is.Equal(incRep.incidents[0].Description, "Livboj kan ha flyttats eller utsatts för åverkan.")
 	is.Equal(incRep.incidents[0].Category, 15)
 }
 
+func TestThatSewageOverflowObservedSendsIncidentOnceWhileOngoing(t *testing.T) {
+	is, incRep, app := testSetup(t)
+
+	fn := overflow("urn:ngsi-ld:Function:overflow-1", true)
+
+	err := app.SewageOverflowObserved(context.Background(), fn)
+	is.NoErr(err)
+
+	err = app.SewageOverflowObserved(context.Background(), fn)
+	is.NoErr(err)
+
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "Bräddning upptäckt vid Bräddpunkt")
+	is.Equal(incRep.incidents[0].Category, 18)
+	is.Equal(incRep.incidents[0].MapCoordinates, "62.390000,17.300000")
+}
+
+func TestThatUnsupportedLifebuoyIsAnError(t *testing.T) {
+	is, incRep, app := testSetup(t)
+
+	err := app.LifebuoyValueUpdated(context.Background(), "default", "urn:ngsi-ld:Device:elt-livboj-01", "off")
+	is.True(err != nil)
+
+	incRep.assertNotCalled(is)
+}
+
+func TestThatDescriptionTemplateIsUsedForItsKind(t *testing.T) {
+	is := is.New(t)
+
+	templates, err := descriptions.Parse([]byte(`
+sewageoverflow: "Bräddning vid {{.Device.Name}} ({{with .Location}}{{.Source}}{{end}})"
+`))
+	is.NoErr(err)
+
+	incRep := newIncidentReporterThatReturns(nil)
+	locator := &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.1, 17.1, nil
+		},
+	}
+
+	app := NewApplication(context.Background(), incRep.f, locator, WithDescriptions(templates))
+
+	is.NoErr(app.SewageOverflowObserved(context.Background(), overflow("urn:ngsi-ld:Function:overflow-1", true)))
+	is.NoErr(app.LifebuoyValueUpdated(context.Background(), "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "off"))
+
+	incRep.assertCallCount(is, 2)
+	is.Equal(incRep.incidents[0].Description, "Bräddning vid Bräddpunkt (event)")
+	is.Equal(incRep.incidents[1].Description, "Livboj kan ha flyttats eller utsatts för åverkan.")
+}
+
+func TestThatDescriptionTemplateIsGivenTheTimeOfTheEvent(t *testing.T) {
+	is := is.New(t)
+
+	templates, err := descriptions.Parse([]byte(`watermeter: "{{.Event.errorType}} {{.Time.Format \"2006-01-02 15:04\"}}"`))
+	is.NoErr(err)
+
+	incRep := newIncidentReporterThatReturns(nil)
+	locator := &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.1, 17.1, nil
+		},
+	}
+
+	app := NewApplication(context.Background(), incRep.f, locator, WithDescriptions(templates))
+
+	deviceID := "urn:ngsi-ld:Device:se:servanet:lora:msva:devId1"
+	err = app.DeviceStateUpdated(context.Background(), deviceID, status(deviceID, 1, time.Date(2024, 2, 27, 8, 30, 0, 0, time.UTC), "Leak"))
+	is.NoErr(err)
+
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "Läckage 2024-02-27 08:30")
+}
+
+func TestThatRejectedIncidentIsNotPostedAgain(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(&incident.RejectedError{Status: "AVVISAT"})
+	app, _ := appWithClock(incRep)
+
+	fn := overflow("urn:ngsi-ld:Function:overflow-1", true)
+
+	is.NoErr(app.SewageOverflowObserved(context.Background(), fn))
+	is.NoErr(app.SewageOverflowObserved(context.Background(), fn))
+
+	incRep.assertCalledOnce(is)
+}
+
+func TestThatTransientFailureIsRetriedOnNextEvent(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(fmt.Errorf("%w: response code 503", incident.ErrTransient))
+	app, _ := appWithClock(incRep)
+
+	fn := overflow("urn:ngsi-ld:Function:overflow-1", true)
+
+	err := app.SewageOverflowObserved(context.Background(), fn)
+	is.True(errors.Is(err, incident.ErrTransient))
+
+	incRep.returnValue = nil
+	is.NoErr(app.SewageOverflowObserved(context.Background(), fn))
+
+	incRep.assertCallCount(is, 2)
+}
+
+func TestThatStateIsKeptAcrossRestarts(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	for range 2 {
+		store, err := state.NewFileStore(path)
+		is.NoErr(err)
+
+		app := NewApplication(context.Background(), incRep.f, &services.EntityLocatorMock{}, WithStateStore(store))
+
+		err = app.SewageOverflowObserved(context.Background(), overflow("urn:ngsi-ld:Function:overflow-1", true))
+		is.NoErr(err)
+		is.NoErr(store.Close())
+	}
+
+	incRep.assertCalledOnce(is)
+}
+
+func TestThatRemindersAreSentWhileFaultPersists(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: overflow
+    kind: sewageoverflow
+    input:
+      source: function.updated
+    trigger:
+      - property: stopwatch.state
+        equals: "true"
+    dedup:
+      key: "${id}"
+      value: stopwatch.state
+    reminder:
+      after: 24h
+      limit: 1
+    incident:
+      description: "Bräddning upptäckt vid ${name}"
+`))
+	is.NoErr(err)
+
+	a, now := appWithClock(incRep, WithRules(rs))
+	fn := overflow("urn:ngsi-ld:Function:overflow-1", true)
+
+	is.NoErr(a.FunctionUpdated(context.Background(), fn))
+	*now = now.Add(12 * time.Hour)
+	is.NoErr(a.FunctionUpdated(context.Background(), fn))
+	incRep.assertCalledOnce(is)
+
+	*now = now.Add(13 * time.Hour)
+	is.NoErr(a.FunctionUpdated(context.Background(), fn))
+	incRep.assertCallCount(is, 2)
+	is.Equal(incRep.incidents[1].Description, "Bräddning upptäckt vid Bräddpunkt (påminnelse 1)")
+
+	*now = now.Add(48 * time.Hour)
+	is.NoErr(a.FunctionUpdated(context.Background(), fn))
+	incRep.assertCallCount(is, 2)
+}
+
+func TestThatRemindersAreSentWithoutFurtherEvents(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: lifebuoy
+    kind: lifebuoy
+    input:
+      source: notification
+      type: Lifebuoy
+    trigger:
+      - property: status
+        equals: "off"
+    dedup:
+      key: "${shortId}:value"
+      value: status
+    reminder:
+      after: 24h
+    incident:
+      description: "Livboj ${shortId} saknas"
+`))
+	is.NoErr(err)
+
+	a, now := appWithClock(incRep, WithRules(rs), WithTenant("default"))
+
+	is.NoErr(a.LifebuoyValueUpdated(context.Background(), "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "off"))
+
+	*now = now.Add(12 * time.Hour)
+	is.NoErr(a.Recheck(context.Background()))
+	incRep.assertCalledOnce(is)
+
+	*now = now.Add(13 * time.Hour)
+	is.NoErr(a.Recheck(context.Background()))
+	is.NoErr(a.Recheck(context.Background()))
+	incRep.assertCallCount(is, 2)
+	is.Equal(incRep.incidents[1].Description, "Livboj elt-livboj-01 saknas (påminnelse 1)")
+}
+
+func TestThatIncidentsOfExpiredStateAreResolved(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: overflow
+    kind: sewageoverflow
+    input:
+      source: function.updated
+    trigger:
+      - property: stopwatch.state
+        equals: "true"
+    dedup:
+      key: "${id}"
+      value: stopwatch.state
+      ttl: 72h
+    resolve:
+      note: "Bräddningen har upphört."
+    incident:
+      description: "Bräddning upptäckt vid ${name}"
+`))
+	is.NoErr(err)
+
+	resolved := []string{}
+	resolver := func(ctx context.Context, incidentID, note string) error {
+		resolved = append(resolved, incidentID)
+		return nil
+	}
+
+	a, now := appWithClock(incRep, WithRules(rs), WithResolver(resolver))
+
+	is.NoErr(a.FunctionUpdated(context.Background(), overflow("urn:ngsi-ld:Function:overflow-1", true)))
+	*now = now.Add(73 * time.Hour)
+	is.NoErr(a.FunctionUpdated(context.Background(), overflow("urn:ngsi-ld:Function:overflow-1", false)))
+
+	is.Equal(resolved, []string{"SP_1"})
+}
+
+func TestThatStoredStateExpiresAfterTTL(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: overflow
+    kind: sewageoverflow
+    input:
+      source: function.updated
+    trigger:
+      - property: stopwatch.state
+        equals: "true"
+    dedup:
+      key: "${id}"
+      value: stopwatch.state
+      ttl: 72h
+    incident:
+      description: "Bräddning upptäckt vid ${name}"
+`))
+	is.NoErr(err)
+
+	a, now := appWithClock(incRep, WithRules(rs))
+	fn := overflow("urn:ngsi-ld:Function:overflow-1", true)
+
+	is.NoErr(a.FunctionUpdated(context.Background(), fn))
+	*now = now.Add(73 * time.Hour)
+	is.NoErr(a.FunctionUpdated(context.Background(), fn))
+
+	incRep.assertCallCount(is, 2)
+	is.Equal(incRep.incidents[1].Description, "Bräddning upptäckt vid Bräddpunkt")
+}
+
+func TestThatIncidentIsClosedWhenLifebuoyIsBack(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	gw := newFakeGateway()
+	defer gw.Close()
+
+	reporter, err := incident.NewIncidentCreator(ctx, gw.URL, "")
+	is.NoErr(err)
+	resolver, err := incident.NewIncidentResolver(ctx, gw.URL, "", incident.WithClosedStatus(3))
+	is.NoErr(err)
+
+	locator := &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.388178, 17.315090, nil
+		},
+	}
+
+	app := NewApplication(ctx, reporter, locator, WithResolver(resolver))
+
+	is.NoErr(app.LifebuoyValueUpdated(ctx, "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "off"))
+	is.NoErr(app.LifebuoyValueUpdated(ctx, "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "off"))
+	is.NoErr(app.LifebuoyValueUpdated(ctx, "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "on"))
+	is.NoErr(app.LifebuoyValueUpdated(ctx, "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "on"))
+
+	is.Equal(gw.requests, []string{
+		"POST /incident/3.0/2281/incident",
+		"PATCH /incident/3.0/2281/incident/feedback/SP_1",
+		"PATCH /incident/3.0/2281/incident/status/SP_1",
+	})
+}
+
+func TestThatIncidentCreatedLaterIsResolved(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	resolved := []string{}
+	resolver := func(ctx context.Context, incidentID, note string) error {
+		resolved = append(resolved, incidentID)
+		return nil
+	}
+
+	// as when incidents are posted through an outbox
+	var reported []models.Incident
+	reporter := func(ctx context.Context, i models.Incident) (string, error) {
+		reported = append(reported, i)
+		return "", nil
+	}
+
+	app := NewApplication(ctx, reporter, &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.388178, 17.315090, nil
+		},
+	}, WithResolver(resolver))
+
+	is.NoErr(app.LifebuoyValueUpdated(ctx, "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "off"))
+	is.Equal(len(reported), 1)
+	is.Equal(reported[0].Key, "elt-livboj-01:value")
+
+	is.NoErr(app.IncidentCreated(ctx, reported[0].Key, "SP_7"))
+	is.NoErr(app.LifebuoyValueUpdated(ctx, "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "on"))
+
+	is.Equal(resolved, []string{"SP_7"})
+}
+
+func TestThatIncidentCreatedAfterStateClearedIsResolvedAtOnce(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	notes := map[string]string{}
+	resolver := func(ctx context.Context, incidentID, note string) error {
+		notes[incidentID] = note
+		return nil
+	}
+
+	// as when an incident is still queued when the condition clears
+	var reported []models.Incident
+	reporter := func(ctx context.Context, i models.Incident) (string, error) {
+		reported = append(reported, i)
+		return "", nil
+	}
+
+	store := state.NewInMemoryStore()
+	app := NewApplication(ctx, reporter, &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.388178, 17.315090, nil
+		},
+	}, WithResolver(resolver), WithStateStore(store))
+
+	is.NoErr(app.LifebuoyValueUpdated(ctx, "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "off"))
+	is.NoErr(app.LifebuoyValueUpdated(ctx, "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", "on"))
+	is.Equal(len(notes), 0)
+
+	is.NoErr(app.IncidentCreated(ctx, reported[0].Key, "SP_7"))
+	is.Equal(notes, map[string]string{"SP_7": "Livbojen är tillbaka på sin plats."})
+
+	entry, _, err := store.Get(ctx, reported[0].Key)
+	is.NoErr(err)
+	is.Equal(entry.Value, "on")
+	is.Equal(len(entry.Incidents), 0)
+
+	// there is no state to record an incident with for an unknown key
+	is.True(app.IncidentCreated(ctx, "unknown:value", "SP_8") != nil)
+	_, exists, _ := store.Get(ctx, "unknown:value")
+	is.True(!exists)
+}
+
+func TestThatQueuedIncidentIsReportedAgainUntilPosted(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	// as when the incident is put on the queue of a guard
+	incRep := newIncidentReporterThatReturns(incident.ErrQueued)
+	store := state.NewInMemoryStore()
+	app, _ := appWithClock(incRep, WithStateStore(store))
+
+	fn := overflow("urn:ngsi-ld:Function:overflow-1", true)
+	is.NoErr(app.SewageOverflowObserved(ctx, fn))
+
+	entry, _, err := store.Get(ctx, "urn:ngsi-ld:Function:overflow-1:stopwatch:overflow")
+	is.NoErr(err)
+	is.True(entry.Queued)
+	is.True(entry.Reported.IsZero())
+
+	// the queue is lost on restart, so the incident is reported again
+	app, _ = appWithClock(incRep, WithStateStore(store))
+	is.NoErr(app.SewageOverflowObserved(ctx, fn))
+	incRep.assertCallCount(is, 2)
+
+	is.NoErr(app.IncidentCreated(ctx, "urn:ngsi-ld:Function:overflow-1:stopwatch:overflow", "SP_9"))
+
+	entry, _, err = store.Get(ctx, "urn:ngsi-ld:Function:overflow-1:stopwatch:overflow")
+	is.NoErr(err)
+	is.True(!entry.Queued)
+	is.Equal(entry.Incidents, []string{"SP_9"})
+
+	is.NoErr(app.SewageOverflowObserved(ctx, fn))
+	incRep.assertCallCount(is, 2)
+}
+
+func TestThatIncidentTurnedAwayByGuardIsRetried(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	incRep := newIncidentReporterThatReturns(fmt.Errorf("%w: response code 503", incident.ErrTransient))
+	guard, err := incident.NewGuard(incRep.f, incident.WithBreaker(1, time.Hour))
+	is.NoErr(err)
+
+	app := NewApplication(ctx, guard.Reporter(), &services.EntityLocatorMock{})
+
+	fn := overflow("urn:ngsi-ld:Function:overflow-1", true)
+	is.True(errors.Is(app.SewageOverflowObserved(ctx, fn), incident.ErrTransient))
+
+	// the open breaker turns the incident away without a queue to put it on
+	err = app.SewageOverflowObserved(ctx, fn)
+	is.True(errors.Is(err, incident.ErrCircuitOpen))
+	incRep.assertCalledOnce(is)
+
+	err = app.SewageOverflowObserved(ctx, fn)
+	is.True(errors.Is(err, incident.ErrCircuitOpen)) // not taken as reported
+}
+
+type fakeGateway struct {
+	*httptest.Server
+	requests []string
+}
+
+func newFakeGateway() *fakeGateway {
+	gw := &fakeGateway{}
+	gw.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Write([]byte(`{"access_token":"ncjklhclabclksabclac","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+
+		gw.requests = append(gw.requests, r.Method+" "+r.URL.Path)
+
+		if r.Method == http.MethodPost {
+			w.Write(fmt.Appendf(nil, `{"status": "INSKICKAT", "incidentId": "SP_%d"}`, len(gw.requests)))
+		}
+	}))
+	return gw
+}
+
+func appWithClock(incRep *incidentReporter, opts ...Option) (IntegrationIncident, *time.Time) {
+	now := time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC)
+
+	a := NewApplication(context.Background(), incRep.f, &services.EntityLocatorMock{}, opts...)
+	a.(*app).now = func() time.Time { return now }
+
+	return a, &now
+}
+
+func TestThatRulesCanBeReplaced(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: door-opened
+    kind: door
+    input:
+      source: function.updated
+      type: presence
+    trigger:
+      - property: presence.state
+        equals: "true"
+    dedup:
+      key: "${id}:presence"
+      value: presence.state
+    incident:
+      category: 42
+      description: "Dörr öppnad vid ${name}"
+`))
+	is.NoErr(err)
+
+	app := NewApplication(context.Background(), incRep.f, &services.EntityLocatorMock{}, WithRules(rs))
+
+	fn := models.FunctionUpdated{Id: "door-1", Type: "presence", Name: "Pumpstation"}
+	fn.Presence = &struct {
+		State bool `json:"state"`
+	}{State: true}
+
+	err = app.FunctionUpdated(context.Background(), fn)
+	is.NoErr(err)
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Category, 42)
+	is.Equal(incRep.incidents[0].Description, "Dörr öppnad vid Pumpstation")
+
+	err = app.SewageOverflowObserved(context.Background(), overflow("urn:ngsi-ld:Function:overflow-1", true))
+	is.NoErr(err)
+	incRep.assertCalledOnce(is)
+}
+
+func TestThatDoorOpenedOutsideOfBusinessHoursIsReported(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: door-opened
+    kind: door
+    input:
+      source: function.updated
+      type: presence
+    trigger:
+      - property: presence.state
+        equals: "true"
+      - property: timestamp
+        outside: {days: [mon, tue, wed, thu, fri], from: "07:00", to: "16:00"}
+    dedup:
+      key: "${id}:presence"
+      value: presence.state
+    incident:
+      category: 42
+      description: "Dörr öppnad vid ${name}"
+      location:
+        source: event
+`))
+	is.NoErr(err)
+
+	app, now := appWithClock(incRep, WithRules(rs))
+
+	door := func(state bool) models.FunctionUpdated {
+		fn := models.FunctionUpdated{Id: "door-1", Type: "presence", Name: "Pumpstation"}
+		fn.Presence = &struct {
+			State bool `json:"state"`
+		}{State: state}
+		return fn
+	}
+
+	// wednesday at 13:00 in Stockholm
+	*now = time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC)
+	is.NoErr(app.FunctionUpdated(context.Background(), door(true)))
+	is.NoErr(app.FunctionUpdated(context.Background(), door(false)))
+	incRep.assertNotCalled(is)
+
+	// and at 22:00
+	*now = time.Date(2024, 2, 28, 21, 0, 0, 0, time.UTC)
+	is.NoErr(app.FunctionUpdated(context.Background(), door(true)))
+	incRep.assertCalledOnce(is)
+}
+
+func TestThatCounterIsReportedWhenItExceedsTheLimitWithinTheWindow(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: gate-count
+    kind: gate
+    input:
+      source: function.updated
+      type: counter
+    window:
+      property: counter.count
+      duration: 1h
+    threshold:
+      property: window.count
+      high: 50
+    trigger:
+      - property: threshold
+        equals: high
+    dedup:
+      key: "${id}:count"
+      value: threshold
+    incident:
+      category: 42
+      description: "Bommen vid ${name} har öppnats ${window.count} gånger den senaste timmen"
+`))
+	is.NoErr(err)
+
+	app, now := appWithClock(incRep, WithRules(rs))
+
+	gate := func(count int) models.FunctionUpdated {
+		fn := models.FunctionUpdated{Id: "gate-1", Type: "counter", Name: "Bommen"}
+		fn.Counter = &struct {
+			Counter int  `json:"counter"`
+			State   bool `json:"state"`
+		}{Counter: count}
+		return fn
+	}
+
+	start := *now
+
+	// 40 openings in the first hour and 20 more an hour later stay below the limit
+	for i, count := range []int{100, 120, 140} {
+		*now = start.Add(time.Duration(i) * 30 * time.Minute)
+		is.NoErr(app.FunctionUpdated(context.Background(), gate(count)))
+	}
+	*now = start.Add(90 * time.Minute)
+	is.NoErr(app.FunctionUpdated(context.Background(), gate(160)))
+	incRep.assertNotCalled(is)
+
+	// the counter is reset and counts 35 more within the same hour
+	*now = start.Add(100 * time.Minute)
+	is.NoErr(app.FunctionUpdated(context.Background(), gate(35)))
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "Bommen vid Bommen har öppnats 75 gånger den senaste timmen")
+}
+
+func TestThatWindowsAreKeptAcrossRestarts(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: gate-count
+    kind: gate
+    input:
+      source: function.updated
+      type: counter
+    window:
+      property: counter.count
+      duration: 1h
+    threshold:
+      property: window.count
+      high: 50
+    trigger:
+      - property: threshold
+        equals: high
+    dedup:
+      key: "${id}:count"
+      value: threshold
+    incident:
+      category: 42
+      description: "Bommen vid ${name} har öppnats ${window.count} gånger den senaste timmen"
+`))
+	is.NoErr(err)
+
+	gate := func(count int) models.FunctionUpdated {
+		fn := models.FunctionUpdated{Id: "gate-1", Type: "counter", Name: "Bommen"}
+		fn.Counter = &struct {
+			Counter int  `json:"counter"`
+			State   bool `json:"state"`
+		}{Counter: count}
+		return fn
+	}
+
+	store := state.NewInMemoryStore()
+
+	app, now := appWithClock(incRep, WithRules(rs), WithStateStore(store))
+	start := *now
+
+	is.NoErr(app.FunctionUpdated(ctx, gate(100)))
+	*now = start.Add(20 * time.Minute)
+	is.NoErr(app.FunctionUpdated(ctx, gate(130)))
+
+	app, now = appWithClock(incRep, WithRules(rs), WithStateStore(store))
+	*now = start.Add(40 * time.Minute)
+	is.NoErr(app.FunctionUpdated(ctx, gate(160)))
+
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "Bommen vid Bommen har öppnats 60 gånger den senaste timmen")
+}
+
+func TestThatBathingSitesAreWatchedDuringTheSeason(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: bathing-temperature
+    kind: bathingsite
+    input:
+      source: function.updated
+      type: waterquality
+    filter:
+      - property: timestamp
+        season: {from: "06-01", to: "08-31"}
+    threshold:
+      property: waterquality.temperature
+      high: 26
+      low: 12
+      hysteresis: 1
+    trigger:
+      - property: threshold
+        in: [high, low]
+    dedup:
+      key: "${id}:temperature"
+      value: threshold
+    incident:
+      category: 42
+      description: "Vattentemperaturen vid ${name} är ${waterquality.temperature} °C"
+  - id: bathing-silent
+    kind: bathingsite
+    input:
+      source: function.updated
+      type: waterquality
+    filter:
+      - property: timestamp
+        season: {from: "06-01", to: "08-31"}
+    silence:
+      after: 6h
+    trigger:
+      - property: silent
+        equals: "true"
+    dedup:
+      key: "${id}:silent"
+      value: silent
+    incident:
+      category: 42
+      description: "Ingen temperatur från ${name} på ${silence}"
+`))
+	is.NoErr(err)
+
+	app, now := appWithClock(incRep, WithRules(rs))
+
+	*now = time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	is.NoErr(app.FunctionUpdated(ctx, waterquality("urn:ngsi-ld:Function:beach-1", 10, *now)))
+	is.NoErr(app.Recheck(ctx))
+	incRep.assertNotCalled(is)
+
+	*now = time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	is.NoErr(app.FunctionUpdated(ctx, waterquality("urn:ngsi-ld:Function:beach-1", 11.5, *now)))
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "Vattentemperaturen vid Norrstrand är 11.5 °C")
+
+	*now = now.Add(5 * time.Hour)
+	is.NoErr(app.Recheck(ctx))
+	incRep.assertCalledOnce(is)
+
+	*now = now.Add(2 * time.Hour)
+	is.NoErr(app.Recheck(ctx))
+	is.NoErr(app.Recheck(ctx))
+	incRep.assertCallCount(is, 2)
+	is.Equal(incRep.incidents[1].Description, "Ingen temperatur från Norrstrand på 7h0m0s")
+
+	// once the season is over, silent sensors are no longer reported
+	is.NoErr(app.FunctionUpdated(ctx, waterquality("urn:ngsi-ld:Function:beach-1", 18, *now)))
+	*now = time.Date(2024, 9, 2, 12, 0, 0, 0, time.UTC)
+	is.NoErr(app.Recheck(ctx))
+	incRep.assertCallCount(is, 2)
+}
+
+func TestThatSilentSourcesAreWatchedAcrossRestarts(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	incRep := newIncidentReporterThatReturns(nil)
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: bathing-silent
+    kind: bathingsite
+    input:
+      source: function.updated
+      type: waterquality
+    silence:
+      after: 6h
+    trigger:
+      - property: silent
+        equals: "true"
+    dedup:
+      key: "${id}:silent"
+      value: silent
+    incident:
+      category: 42
+      description: "Ingen temperatur från ${name} på ${silence}"
+`))
+	is.NoErr(err)
+
+	start := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+
+	store, err := state.NewFileStore(path)
+	is.NoErr(err)
+	app, now := appWithClock(incRep, WithRules(rs), WithStateStore(store))
+	*now = start
+	is.NoErr(app.FunctionUpdated(ctx, waterquality("urn:ngsi-ld:Function:beach-1", 10, start)))
+	is.NoErr(store.Close())
+
+	store, err = state.NewFileStore(path)
+	is.NoErr(err)
+	defer store.Close()
+
+	app, now = appWithClock(incRep, WithRules(rs), WithStateStore(store))
+	*now = start.Add(7 * time.Hour)
+	is.NoErr(app.Recheck(ctx))
+
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "Ingen temperatur från Norrstrand på 7h0m0s")
+}
+
+func waterquality(id string, temperature float64, at time.Time) models.FunctionUpdated {
+	fn := models.FunctionUpdated{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(waterqualityJsonFormat, id, temperature, at.Format(time.RFC3339))), &fn)
+	if err != nil {
+		panic(err)
+	}
+	return fn
+}
+
+const waterqualityJsonFormat string = `{
+	"id": "%s",
+	"type": "waterquality",
+	"subType": "beach",
+	"name": "Norrstrand",
+	"location": {"latitude": 62.4, "longitude": 17.35},
+	"waterquality": {"temperature": %g, "timestamp": "%s"}
+}`
+
+func TestThatTimerRunningForTooLongIsReportedAndNotedWhenItStops(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: pump-running
+    kind: pump
+    input:
+      source: function.updated
+      type: timer
+    timer:
+      max: 6h
+    trigger:
+      - property: timer.overrun
+        equals: "true"
+    dedup:
+      key: "${id}:timer"
+      value: timer.overrun
+    resolve:
+      note: "Pumpen vid ${name} stannade efter ${timer.elapsed}."
+    incident:
+      category: 42
+      description: "Pumpen vid ${name} har gått i ${timer.elapsed}"
+`))
+	is.NoErr(err)
+
+	notes := map[string]string{}
+	resolver := func(ctx context.Context, incidentID, note string) error {
+		notes[incidentID] = note
+		return nil
+	}
+
+	app, now := appWithClock(incRep, WithRules(rs), WithResolver(resolver))
+	start := *now
+
+	is.NoErr(app.FunctionUpdated(ctx, timer("urn:ngsi-ld:Function:pump-1", start, nil)))
+
+	*now = start.Add(5 * time.Hour)
+	is.NoErr(app.Recheck(ctx))
+	incRep.assertNotCalled(is)
+
+	*now = start.Add(6*time.Hour + time.Minute)
+	is.NoErr(app.Recheck(ctx))
+	is.NoErr(app.Recheck(ctx))
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "Pumpen vid Pumpstation har gått i 6h1m0s")
+
+	end := start.Add(7*time.Hour + 30*time.Minute)
+	*now = end
+	is.NoErr(app.FunctionUpdated(ctx, timer("urn:ngsi-ld:Function:pump-1", start, &end)))
+	is.Equal(notes, map[string]string{"SP_1": "Pumpen vid Pumpstation stannade efter 7h30m0s."})
+
+	*now = end.Add(24 * time.Hour)
+	is.NoErr(app.Recheck(ctx))
+	incRep.assertCalledOnce(is)
+}
+
+func timer(id string, start time.Time, end *time.Time) models.FunctionUpdated {
+	fn := models.FunctionUpdated{Id: id, Type: "timer", Name: "Pumpstation"}
+	fn.Timer = &struct {
+		StartTime time.Time      `json:"startTime"`
+		EndTime   *time.Time     `json:"endTime,omitempty"`
+		Duration  *time.Duration `json:"duration,omitempty"`
+		State     bool           `json:"state"`
+	}{StartTime: start, EndTime: end, State: end == nil}
+	if end != nil {
+		d := end.Sub(start)
+		fn.Timer.Duration = &d
+	}
+	return fn
+}
+
+func TestThatBuildingPowerAndEnergyAnomaliesAreReported(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: building-power
+    kind: building
+    input:
+      source: function.updated
+      type: building
+    threshold:
+      property: building.power
+      high: 500
+      sources:
+        building-1: {high: 100}
+    trigger:
+      - property: threshold
+        equals: high
+    dedup:
+      key: "${id}:power"
+      value: threshold
+    incident:
+      category: 42
+      description: "Effekten i ${name} är ${building.power} kW (gräns ${threshold.limit} kW)"
+  - id: building-energy
+    kind: building
+    input:
+      source: function.updated
+      type: building
+    window:
+      property: building.energy
+      duration: 24h
+    baseline:
+      property: window.count
+      period: 24h
+      periods: 3
+    threshold:
+      property: baseline.deviation
+      high: 30
+      low: -30
+    trigger:
+      - property: threshold
+        in: [high, low]
+    dedup:
+      key: "${id}:energy"
+      value: threshold
+    incident:
+      category: 42
+      description: "Förbrukningen i ${name} avviker ${baseline.deviation} % från normalt"
+`))
+	is.NoErr(err)
+
+	app, now := appWithClock(incRep, WithRules(rs))
+	start := *now
+
+	is.NoErr(app.FunctionUpdated(ctx, building("building-2", 0, 150)))
+	incRep.assertNotCalled(is)
+
+	// four days at 10 kWh an hour, and then 20 kWh an hour
+	energy := 0.0
+	for hour := range 4*24 + 9 {
+		*now = start.Add(time.Duration(hour) * time.Hour)
+		if hour > 0 && hour <= 4*24 {
+			energy += 10
+		} else if hour > 4*24 {
+			energy += 20
+		}
+		is.NoErr(app.FunctionUpdated(ctx, building("building-1", energy, 80)))
+	}
+
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "Förbrukningen i Stadshuset avviker 33.3 % från normalt")
+
+	is.NoErr(app.FunctionUpdated(ctx, building("building-1", energy, 150)))
+	incRep.assertCallCount(is, 2)
+	is.Equal(incRep.incidents[1].Description, "Effekten i Stadshuset är 150 kW (gräns 100 kW)")
+}
+
+func TestThatBaselinesAreKeptAcrossRestarts(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: building-power
+    kind: building
+    input:
+      source: function.updated
+      type: building
+    baseline:
+      property: building.power
+      period: 24h
+      periods: 1
+    threshold:
+      property: baseline.deviation
+      high: 30
+    trigger:
+      - property: threshold
+        equals: high
+    dedup:
+      key: "${id}:power"
+      value: threshold
+    incident:
+      category: 42
+      description: "Effekten i ${name} avviker ${baseline.deviation} % från normalt"
+`))
+	is.NoErr(err)
+
+	store := state.NewInMemoryStore()
+
+	app, now := appWithClock(incRep, WithRules(rs), WithStateStore(store))
+	start := *now
+
+	is.NoErr(app.FunctionUpdated(ctx, building("building-1", 0, 100)))
+	*now = start.Add(24 * time.Hour)
+	is.NoErr(app.FunctionUpdated(ctx, building("building-1", 0, 100)))
+
+	app, now = appWithClock(incRep, WithRules(rs), WithStateStore(store))
+	*now = start.Add(25 * time.Hour)
+	is.NoErr(app.FunctionUpdated(ctx, building("building-1", 0, 150)))
+
+	incRep.assertCalledOnce(is)
+	is.Equal(incRep.incidents[0].Description, "Effekten i Stadshuset avviker 50 % från normalt")
+}
+
+func building(id string, energy, power float64) models.FunctionUpdated {
+	fn := models.FunctionUpdated{Id: id, Type: "building", Name: "Stadshuset"}
+	fn.Building = &struct {
+		Energy float64 `json:"energy"`
+		Power  float64 `json:"power"`
+	}{Energy: energy, Power: power}
+	return fn
+}
+
+func TestThatLevelIncidentsAreNotRepeatedWhileTheLevelHoversAroundTheLimit(t *testing.T) {
+	is := is.New(t)
+	incRep := newIncidentReporterThatReturns(nil)
+
+	rs, err := rules.Parse([]byte(`
+rules:
+  - id: sump-level
+    kind: level
+    input:
+      source: function.updated
+      type: level
+      subType: sump
+    threshold:
+      property: level.percent
+      high: 80
+      low: 10
+      hysteresis: 5
+    trigger:
+      - property: threshold
+        in: [high, low]
+    dedup:
+      key: "${id}:level"
+      value: threshold
+    incident:
+      category: 42
+      description: "Nivån i ${name} är ${threshold} (${level.percent} %, gräns ${threshold.limit} %)"
+      location:
+        source: event
+`))
+	is.NoErr(err)
+
+	app := NewApplication(context.Background(), incRep.f, &services.EntityLocatorMock{}, WithRules(rs))
+
+	for _, percent := range []float64{50, 81, 78, 82, 76, 74, 9} {
+		is.NoErr(app.FunctionUpdated(context.Background(), level("urn:ngsi-ld:Function:sump-1", percent)))
+	}
+
+	incRep.assertCallCount(is, 2)
+	is.Equal(incRep.incidents[0].Description, "Nivån i Pumpgrop är high (81 %, gräns 80 %)")
+	is.Equal(incRep.incidents[0].MapCoordinates, "62.390000,17.300000")
+	is.Equal(incRep.incidents[1].Description, "Nivån i Pumpgrop är low (9 %, gräns 10 %)")
+}
+
+func level(id string, percent float64) models.FunctionUpdated {
+	fn := models.FunctionUpdated{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(levelJsonFormat, id, percent)), &fn)
+	if err != nil {
+		panic(err)
+	}
+	return fn
+}
+
+const levelJsonFormat string = `{
+	"id": "%s",
+	"type": "level",
+	"subType": "sump",
+	"name": "Pumpgrop",
+	"location": {"latitude": 62.39, "longitude": 17.3},
+	"level": {"current": 1.2, "percent": %g}
+}`
+
+func overflow(id string, state bool) models.FunctionUpdated {
+	fn := models.FunctionUpdated{}
+	err := json.Unmarshal([]byte(fmt.Sprintf(overflowJsonFormat, id, state)), &fn)
+	if err != nil {
+		panic(err)
+	}
+	return fn
+}
+
+const overflowJsonFormat string = `{
+	"id": "%s",
+	"type": "stopwatch",
+	"subType": "overflow",
+	"name": "Bräddpunkt",
+	"location": {"latitude": 62.39, "longitude": 17.3},
+	"stopwatch": {"startTime": "2024-05-31T12:12:12Z", "state": %t, "count": 1}
+}`
+
 func testSetup(t *testing.T) (*is.I, *incidentReporter, IntegrationIncident) {
 	is := is.New(t)
 	incRep := newIncidentReporterThatReturns(nil)
@@ -167,8 +1426,11 @@ func (r *incidentReporter) assertNotCalled(is *is.I) {
 	r.assertCallCount(is, 0)
 }
 
-func (r *incidentReporter) f(ctx context.Context, incident models.Incident) error {
+func (r *incidentReporter) f(ctx context.Context, incident models.Incident) (string, error) {
 	r.callCount++
 	r.incidents = append(r.incidents, incident)
-	return r.returnValue
+	if r.returnValue != nil {
+		return "", r.returnValue
+	}
+	return fmt.Sprintf("SP_%d", r.callCount), nil
 }
@@ -0,0 +1,94 @@
+package categories
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Mapping maps an incident kind, as named by the rules, to the category id
+// used by the incident API.
+type Mapping map[string]int
+
+func Default() Mapping {
+	return Mapping{
+		"lifebuoy":       15,
+		"watermeter":     17,
+		"sewageoverflow": 18,
+	}
+}
+
+// Parse reads a mapping on the form "kind=category,kind=category"
+func Parse(s string) (Mapping, error) {
+	m := Mapping{}
+
+	for pair := range strings.SplitSeq(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kind, category, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid category mapping \"%s\", expected kind=category", pair)
+		}
+
+		id, err := strconv.Atoi(strings.TrimSpace(category))
+		if err != nil {
+			return nil, fmt.Errorf("invalid category for %s: %w", kind, err)
+		}
+
+		m[strings.TrimSpace(kind)] = id
+	}
+
+	return m, m.Validate()
+}
+
+// Load reads a mapping from a YAML (or JSON) file with kinds as keys
+func Load(path string) (Mapping, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read category mapping: %w", err)
+	}
+
+	m := Mapping{}
+	err = yaml.Unmarshal(b, &m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal category mapping: %w", err)
+	}
+
+	return m, m.Validate()
+}
+
+func (m Mapping) Validate() error {
+	var errs []error
+
+	for _, kind := range slices.Sorted(maps.Keys(m)) {
+		if kind == "" {
+			errs = append(errs, fmt.Errorf("category mapping contains an empty kind"))
+		}
+		if m[kind] <= 0 {
+			errs = append(errs, fmt.Errorf("invalid category %d for %s", m[kind], kind))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Merge returns a new mapping where the entries in other replace those in m
+func (m Mapping) Merge(other Mapping) Mapping {
+	merged := maps.Clone(m)
+	maps.Copy(merged, other)
+	return merged
+}
+
+func (m Mapping) Category(kind string) (int, bool) {
+	id, ok := m[kind]
+	return id, ok
+}
@@ -0,0 +1,47 @@
+package categories
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParseMappingFromString(t *testing.T) {
+	is := is.New(t)
+
+	m, err := Parse("watermeter=117, lifebuoy=115")
+	is.NoErr(err)
+
+	is.Equal(m["watermeter"], 117)
+	is.Equal(m["lifebuoy"], 115)
+}
+
+func TestParseRejectsInvalidMapping(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse("watermeter")
+	is.True(err != nil)
+
+	_, err = Parse("watermeter=abc")
+	is.True(err != nil)
+
+	_, err = Parse("watermeter=0")
+	is.True(err != nil)
+}
+
+func TestLoadAndMergeMapping(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "categories.yaml")
+	is.NoErr(os.WriteFile(path, []byte("sewageoverflow: 28\nlevel: 30\n"), 0644))
+
+	m, err := Load(path)
+	is.NoErr(err)
+
+	merged := Default().Merge(m)
+	is.Equal(merged["sewageoverflow"], 28)
+	is.Equal(merged["level"], 30)
+	is.Equal(merged["lifebuoy"], 15)
+}
@@ -0,0 +1,296 @@
+package descriptions
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+	_ "time/tzdata"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Data is what a description template is rendered with. Location is nil when
+// the incident could not be located and Previous is nil for the first event
+// seen for the dedup key, so templates should guard them with {{with}}.
+type Data struct {
+	Kind     string            `json:"kind"`
+	Rule     string            `json:"rule"`
+	Time     time.Time         `json:"time"`
+	Device   Device            `json:"device"`
+	Location *Location         `json:"location,omitempty"`
+	Event    map[string]string `json:"event"`
+	Previous *Previous         `json:"previous,omitempty"`
+	Reminder int               `json:"reminder"`
+}
+
+// Device is the device, or function, that the event came from
+type Device struct {
+	ID      string `json:"id"`
+	ShortID string `json:"shortId"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	SubType string `json:"subType"`
+	Class   string `json:"class"`
+	Tenant  string `json:"tenant"`
+}
+
+// Location is where the incident was placed and the location source it was
+// taken from
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Source    string  `json:"source"`
+}
+
+// Previous is the state stored for the dedup key before the event arrived
+type Previous struct {
+	Value     string    `json:"value"`
+	Updated   time.Time `json:"updated"`
+	Reported  time.Time `json:"reported"`
+	Reminders int       `json:"reminders"`
+	Incidents []string  `json:"incidents"`
+}
+
+// Templates holds a description template per incident kind
+type Templates struct {
+	sources   map[string]string
+	templates map[string]*template.Template
+}
+
+func Load(path string) (*Templates, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read description templates: %w", err)
+	}
+
+	return Parse(b)
+}
+
+// Parse decodes templates keyed by incident kind from YAML (or JSON). Each
+// template is rendered with sample data, with and without a location and a
+// previous state, so that mistakes are found at startup rather than when an
+// incident is reported.
+func Parse(b []byte) (*Templates, error) {
+	sources := map[string]string{}
+
+	err := yaml.Unmarshal(b, &sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal description templates: %w", err)
+	}
+
+	t := &Templates{sources: sources, templates: map[string]*template.Template{}}
+	var errs []error
+
+	for _, kind := range slices.Sorted(maps.Keys(sources)) {
+		tmpl, err := Compile(kind, sources[kind])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		t.templates[kind] = tmpl
+	}
+
+	return t, errors.Join(errs...)
+}
+
+// Compile parses a template and renders it with the sample data
+func Compile(kind, text string) (*template.Template, error) {
+	tmpl, err := template.New(kind).Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid description template for %s: %w", kind, err)
+	}
+
+	for _, sample := range []Data{Sample(), {Kind: kind, Event: map[string]string{}}} {
+		if _, err := render(tmpl, sample); err != nil {
+			return nil, fmt.Errorf("description template for %s can not be rendered: %w", kind, err)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// Kinds returns the kinds that have a template, in sorted order
+func (t *Templates) Kinds() []string {
+	if t == nil {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(t.templates))
+}
+
+// Source returns the text of the template for a kind
+func (t *Templates) Source(kind string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	s, ok := t.sources[kind]
+	return s, ok
+}
+
+// Render renders the template for the kind of the data. It reports false if
+// there is no template for the kind.
+func (t *Templates) Render(data Data) (string, bool, error) {
+	if t == nil {
+		return "", false, nil
+	}
+
+	tmpl, ok := t.templates[data.Kind]
+	if !ok {
+		return "", false, nil
+	}
+
+	s, err := render(tmpl, data)
+	return s, true, err
+}
+
+// ErrNoTemplate is returned when previewing a kind that has no template
+var ErrNoTemplate = errors.New("no description template for kind")
+
+// Preview renders the given template text, or the template for the kind if
+// the text is empty, with the given data or the sample data
+func (t *Templates) Preview(kind, text string, data *Data) (string, error) {
+	if text == "" {
+		var ok bool
+		if text, ok = t.Source(kind); !ok {
+			return "", fmt.Errorf("%w %s", ErrNoTemplate, kind)
+		}
+	}
+
+	tmpl, err := Compile(kind, text)
+	if err != nil {
+		return "", err
+	}
+
+	if data == nil {
+		sample := Sample()
+		data = &sample
+	}
+	data.Kind = kind
+
+	return render(tmpl, *data)
+}
+
+// Sample returns data that looks like that of a watermeter incident, for use
+// when validating and previewing templates
+func Sample() Data {
+	now := time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC)
+
+	return Data{
+		Kind: "watermeter",
+		Rule: "watermeter-fault",
+		Time: now,
+		Device: Device{
+			ID:      "urn:ngsi-ld:Device:se:servanet:lora:msva:05394167",
+			ShortID: "05394167",
+			Name:    "Vattenmätare Storgatan 1",
+			Class:   "watermeter",
+			Tenant:  "default",
+		},
+		Location: &Location{Latitude: 62.388178, Longitude: 17.315090, Source: "entity"},
+		Event: map[string]string{
+			"statusCode": "1",
+			"messages":   "Leak",
+			"errorType":  "Läckage",
+			"timestamp":  now.Add(-5 * time.Minute).Format(time.RFC3339Nano),
+		},
+		Previous: &Previous{
+			Value:   "0",
+			Updated: now.Add(-24 * time.Hour),
+		},
+	}
+}
+
+func render(t *template.Template, data Data) (string, error) {
+	var b strings.Builder
+	err := t.Execute(&b, data)
+	return strings.TrimSpace(b.String()), err
+}
+
+// funcs are available to the description templates
+var funcs = template.FuncMap{
+	"formatTime": formatTime,
+	"elapsed":    elapsed,
+	"duration":   duration,
+}
+
+// formatTime formats a time, or a timestamp in RFC 3339 as found in the event
+// payload, with a layout such as "2006-01-02 15:04". Times are shown in the
+// local time of Sweden.
+func formatTime(layout string, v any) (string, error) {
+	t, err := toTime(v)
+	if err != nil || t.IsZero() {
+		return "", err
+	}
+	return t.In(stockholm).Format(layout), nil
+}
+
+// elapsed returns the duration between two times or timestamps
+func elapsed(from, to any) (time.Duration, error) {
+	start, err := toTime(from)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := toTime(to)
+	if err != nil {
+		return 0, err
+	}
+
+	return end.Sub(start), nil
+}
+
+// duration formats a duration, or a number of seconds, rounded to the second
+func duration(v any) (string, error) {
+	var d time.Duration
+
+	switch x := v.(type) {
+	case time.Duration:
+		d = x
+	case string:
+		if x == "" {
+			return "", nil
+		}
+		parsed, err := time.ParseDuration(x)
+		if err != nil {
+			parsed, err = time.ParseDuration(x + "s")
+			if err != nil {
+				return "", fmt.Errorf("invalid duration %s", x)
+			}
+		}
+		d = parsed
+	default:
+		return "", fmt.Errorf("can not format %T as a duration", v)
+	}
+
+	return d.Round(time.Second).String(), nil
+}
+
+func toTime(v any) (time.Time, error) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case *time.Time:
+		if x == nil {
+			return time.Time{}, nil
+		}
+		return *x, nil
+	case string:
+		if x == "" {
+			return time.Time{}, nil
+		}
+		return time.Parse(time.RFC3339Nano, x)
+	}
+	return time.Time{}, fmt.Errorf("can not use %T as a time", v)
+}
+
+var stockholm = func() *time.Location {
+	loc, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}()
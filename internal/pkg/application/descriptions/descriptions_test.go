@@ -0,0 +1,65 @@
+package descriptions
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatTemplatesAreRenderedPerKind(t *testing.T) {
+	is := is.New(t)
+
+	templates, err := Parse([]byte(`
+watermeter: |
+  {{.Event.errorType}} på {{.Device.Name}}{{with .Previous}}, felfri sedan {{formatTime "2006-01-02 15:04" .Updated}}{{end}}
+sewageoverflow: "Bräddning vid {{.Device.Name}} sedan {{formatTime \"15:04\" .Event.timestamp}}"
+`))
+	is.NoErr(err)
+	is.Equal(templates.Kinds(), []string{"sewageoverflow", "watermeter"})
+
+	description, ok, err := templates.Render(Sample())
+	is.NoErr(err)
+	is.True(ok)
+	is.Equal(description, "Läckage på Vattenmätare Storgatan 1, felfri sedan 2024-02-27 13:00")
+
+	_, ok, err = templates.Render(Data{Kind: "lifebuoy"})
+	is.NoErr(err)
+	is.True(!ok)
+}
+
+func TestThatInvalidTemplatesAreRejectedAtStartup(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte(`
+watermeter: "{{.Device.Adress}}"
+lifebuoy: "{{.Previous.Value}}"
+sewageoverflow: "{{if}}"
+`))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "watermeter can not be rendered"))
+	// templates must not assume that there is a previous state
+	is.True(strings.Contains(err.Error(), "lifebuoy can not be rendered"))
+	is.True(strings.Contains(err.Error(), "invalid description template for sewageoverflow"))
+}
+
+func TestThatTemplatesCanBePreviewed(t *testing.T) {
+	is := is.New(t)
+
+	templates, err := Parse([]byte(`watermeter: "{{.Device.ShortID}}: {{.Event.errorType}}"`))
+	is.NoErr(err)
+
+	description, err := templates.Preview("watermeter", "", nil)
+	is.NoErr(err)
+	is.Equal(description, "05394167: Läckage")
+
+	data := &Data{Event: map[string]string{"stopwatch.duration": "5400"}, Time: time.Now()}
+	description, err = templates.Preview("sewageoverflow", "Bräddning i {{duration (index .Event \"stopwatch.duration\")}}", data)
+	is.NoErr(err)
+	is.Equal(description, "Bräddning i 1h30m0s")
+
+	_, err = templates.Preview("sewageoverflow", "", nil)
+	is.True(errors.Is(err, ErrNoTemplate))
+}
@@ -0,0 +1,195 @@
+package devices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"gopkg.in/yaml.v3"
+)
+
+// Pattern matches device ids by exactly one of a prefix, a glob as understood
+// by path.Match, or a regular expression
+type Pattern struct {
+	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	Glob   string `yaml:"glob,omitempty" json:"glob,omitempty"`
+	Regex  string `yaml:"regex,omitempty" json:"regex,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Class is a class of devices, such as watermeters, that are handled by the
+// same rules. A device belongs to the class if it matches any of the include
+// patterns, or there are none, and none of the exclude patterns.
+type Class struct {
+	Name    string    `yaml:"name" json:"name"`
+	Include []Pattern `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude []Pattern `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+}
+
+// Filter sorts devices into classes. Classes are tried in the order they are
+// declared and devices that do not belong to any class are dropped.
+type Filter struct {
+	Classes []Class `yaml:"classes" json:"classes"`
+}
+
+// Default returns the filter used unless a filter file is configured, which
+// only lets watermeters from Servanet through
+func Default() *Filter {
+	f, err := Parse([]byte(`
+classes:
+  - name: watermeter
+    include:
+      - glob: "*se:servanet:lora:msva:*"
+`))
+	if err != nil {
+		panic(fmt.Sprintf("default device filter is invalid: %s", err.Error()))
+	}
+	return f
+}
+
+func Load(path string) (*Filter, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device filter: %w", err)
+	}
+
+	return Parse(b)
+}
+
+// Parse decodes a filter from YAML (or JSON) and compiles its patterns
+func Parse(b []byte) (*Filter, error) {
+	f := &Filter{}
+
+	err := yaml.Unmarshal(b, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device filter: %w", err)
+	}
+
+	return f, f.compile()
+}
+
+func (f *Filter) compile() error {
+	var errs []error
+	names := map[string]bool{}
+
+	for i := range f.Classes {
+		c := &f.Classes[i]
+
+		if c.Name == "" {
+			errs = append(errs, fmt.Errorf("device class %d has no name", i))
+		} else if names[c.Name] {
+			errs = append(errs, fmt.Errorf("duplicate device class %s", c.Name))
+		}
+		names[c.Name] = true
+
+		for _, patterns := range [][]Pattern{c.Include, c.Exclude} {
+			for j := range patterns {
+				if err := patterns[j].compile(); err != nil {
+					errs = append(errs, fmt.Errorf("device class %s: %w", c.Name, err))
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Classify returns the class of the device, or false if the device is to be
+// dropped. Dropped events are counted, and only logged at debug level since a
+// shared broker may send events from any number of other devices.
+func (f *Filter) Classify(ctx context.Context, deviceID string) (string, bool) {
+	excluded := ""
+
+	for _, c := range f.Classes {
+		if len(c.Include) > 0 && !matchesAny(c.Include, deviceID) {
+			continue
+		}
+
+		if matchesAny(c.Exclude, deviceID) {
+			excluded = c.Name
+			continue
+		}
+
+		return c.Name, true
+	}
+
+	f.dropped(ctx, deviceID, excluded)
+
+	return "", false
+}
+
+func (f *Filter) dropped(ctx context.Context, deviceID, excluded string) {
+	reason := "no matching class"
+	if excluded != "" {
+		reason = "excluded from " + excluded
+	}
+
+	droppedDevices.Add(ctx, 1, metric.WithAttributes(attribute.String("class", excluded)))
+
+	logging.GetFromContext(ctx).Debug("dropping event from device", "device_id", deviceID, "reason", reason)
+}
+
+func (p *Pattern) compile() error {
+	set := 0
+	for _, s := range []string{p.Prefix, p.Glob, p.Regex} {
+		if s != "" {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return fmt.Errorf("device pattern must have exactly one of prefix, glob and regex")
+	}
+
+	if p.Glob != "" {
+		if _, err := path.Match(p.Glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %s: %w", p.Glob, err)
+		}
+	}
+
+	if p.Regex != "" {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex %s: %w", p.Regex, err)
+		}
+		p.re = re
+	}
+
+	return nil
+}
+
+func (p Pattern) Matches(deviceID string) bool {
+	switch {
+	case p.Prefix != "":
+		return strings.HasPrefix(deviceID, p.Prefix)
+	case p.Glob != "":
+		ok, _ := path.Match(p.Glob, deviceID)
+		return ok
+	case p.re != nil:
+		return p.re.MatchString(deviceID)
+	}
+	return false
+}
+
+func matchesAny(patterns []Pattern, deviceID string) bool {
+	for _, p := range patterns {
+		if p.Matches(deviceID) {
+			return true
+		}
+	}
+	return false
+}
+
+var meter = otel.Meter("integration-incident/devices")
+
+var droppedDevices, _ = meter.Int64Counter("incident.devices.dropped",
+	metric.WithDescription("Number of device events dropped by the device filter, by the class that excluded the device, if any"))
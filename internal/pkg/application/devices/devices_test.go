@@ -0,0 +1,74 @@
+package devices
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatDevicesAreClassified(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	f, err := Parse([]byte(`
+classes:
+  - name: watermeter
+    include:
+      - glob: "*se:servanet:lora:msva:*"
+      - prefix: "urn:ngsi-ld:Device:kamstrup:"
+    exclude:
+      - regex: ":test-[0-9]+$"
+  - name: sensor
+    exclude:
+      - prefix: "urn:ngsi-ld:Device:kamstrup:"
+`))
+	is.NoErr(err)
+
+	for deviceID, expected := range map[string]string{
+		"urn:ngsi-ld:Device:se:servanet:lora:msva:05394167": "watermeter",
+		"urn:ngsi-ld:Device:kamstrup:1234":                  "watermeter",
+		"urn:ngsi-ld:Device:se:servanet:lora:msva:test-1":   "sensor",
+		"urn:ngsi-ld:Device:elsys:ers-1":                    "sensor",
+		"urn:ngsi-ld:Device:kamstrup:test-2":                "",
+	} {
+		class, ok := f.Classify(ctx, deviceID)
+		is.Equal(ok, expected != "")
+		is.Equal(class, expected)
+	}
+}
+
+func TestThatDefaultFilterOnlyAcceptsServanetWatermeters(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	f := Default()
+
+	class, ok := f.Classify(ctx, "se:servanet:lora:msva:05394167")
+	is.True(ok)
+	is.Equal(class, "watermeter")
+
+	_, ok = f.Classify(ctx, "notawatermeter")
+	is.True(!ok)
+}
+
+func TestThatInvalidPatternsAreRejected(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte(`
+classes:
+  - name: watermeter
+    include:
+      - regex: "msva:("
+      - glob: "[msva"
+      - prefix: "se:"
+        glob: "se:*"
+  - name: watermeter
+`))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "invalid regex"))
+	is.True(strings.Contains(err.Error(), "invalid glob"))
+	is.True(strings.Contains(err.Error(), "exactly one of prefix, glob and regex"))
+	is.True(strings.Contains(err.Error(), "duplicate device class watermeter"))
+}
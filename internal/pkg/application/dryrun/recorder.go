@@ -0,0 +1,141 @@
+package dryrun
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+const (
+	ActionReport  string = "report"
+	ActionResolve string = "resolve"
+)
+
+// Record is an incident that would have been posted, or an incident that
+// would have been resolved, had the service not been running as a dry run
+type Record struct {
+	Time       time.Time        `json:"time"`
+	Action     string           `json:"action"`
+	IncidentID string           `json:"incidentId"`
+	Key        string           `json:"key,omitempty"`
+	Tenant     string           `json:"tenant,omitempty"`
+	Incident   *models.Incident `json:"incident,omitempty"`
+	Note       string           `json:"note,omitempty"`
+}
+
+// Filter selects records. Zero values match all records and a Limit of zero
+// means no limit.
+type Filter struct {
+	Action   string
+	Category int
+	Since    time.Time
+	Limit    int
+}
+
+// Recorder takes the place of the incident API. Everything that is reported
+// or resolved is logged and kept in a ring buffer, where the oldest records
+// are dropped once the buffer is full.
+type Recorder struct {
+	mx      sync.Mutex
+	records []Record
+	next    int
+	full    bool
+	count   int
+	now     func() time.Time
+}
+
+func NewRecorder(size int) (*Recorder, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("recorder requires room for at least one record")
+	}
+
+	return &Recorder{
+		records: make([]Record, size),
+		now:     func() time.Time { return time.Now().UTC() },
+	}, nil
+}
+
+// Reporter returns a CreatorFunc that records the incident and gives it a
+// made up id, so that it can be resolved later on
+func (r *Recorder) Reporter() incident.CreatorFunc {
+	return func(ctx context.Context, i models.Incident) (string, error) {
+		r.mx.Lock()
+		r.count++
+		incidentID := fmt.Sprintf("DRYRUN_%d", r.count)
+		r.mx.Unlock()
+
+		b, _ := json.Marshal(i)
+		logging.GetFromContext(ctx).Info("dry run, incident not posted", "incident_id", incidentID, "key", i.Key, "incident", string(b))
+
+		r.add(Record{Action: ActionReport, IncidentID: incidentID, Key: i.Key, Tenant: i.Tenant, Incident: &i})
+
+		return incidentID, nil
+	}
+}
+
+// Resolver returns a ResolverFunc that records the resolution
+func (r *Recorder) Resolver() incident.ResolverFunc {
+	return func(ctx context.Context, incidentID, note string) error {
+		logging.GetFromContext(ctx).Info("dry run, incident not resolved", "incident_id", incidentID, "note", note)
+
+		r.add(Record{Action: ActionResolve, IncidentID: incidentID, Note: note})
+
+		return nil
+	}
+}
+
+// Query returns the records that match the filter, oldest first. If there are
+// more than Limit matches the most recent ones are returned.
+func (r *Recorder) Query(f Filter) []Record {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	ordered := r.records[:r.next]
+	if r.full {
+		ordered = append(append([]Record{}, r.records[r.next:]...), r.records[:r.next]...)
+	}
+
+	matches := []Record{}
+	for _, rec := range ordered {
+		if f.matches(rec) {
+			matches = append(matches, rec)
+		}
+	}
+
+	if f.Limit > 0 && len(matches) > f.Limit {
+		matches = matches[len(matches)-f.Limit:]
+	}
+
+	return matches
+}
+
+func (r *Recorder) add(rec Record) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	rec.Time = r.now()
+
+	r.records[r.next] = rec
+	r.next = (r.next + 1) % len(r.records)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (f Filter) matches(rec Record) bool {
+	if f.Action != "" && f.Action != rec.Action {
+		return false
+	}
+
+	if f.Category != 0 && (rec.Incident == nil || rec.Incident.Category != f.Category) {
+		return false
+	}
+
+	return f.Since.IsZero() || !rec.Time.Before(f.Since)
+}
@@ -0,0 +1,67 @@
+package dryrun
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-incident/internal/pkg/application"
+	"github.com/diwise/integration-incident/internal/pkg/application/services"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/matryer/is"
+)
+
+func TestThatOldestRecordsAreDropped(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	r, err := NewRecorder(3)
+	is.NoErr(err)
+
+	for _, category := range []int{15, 17, 18, 17} {
+		_, err := r.Reporter()(ctx, *models.NewIncident(category, "beskrivning"))
+		is.NoErr(err)
+	}
+
+	records := r.Query(Filter{})
+	is.Equal(len(records), 3)
+	is.Equal(records[0].IncidentID, "DRYRUN_2")
+	is.Equal(records[2].IncidentID, "DRYRUN_4")
+
+	records = r.Query(Filter{Category: 17})
+	is.Equal(len(records), 2)
+
+	records = r.Query(Filter{Category: 17, Limit: 1})
+	is.Equal(records[0].IncidentID, "DRYRUN_4")
+
+	records = r.Query(Filter{Since: time.Now().Add(time.Hour)})
+	is.Equal(len(records), 0)
+}
+
+func TestThatDedupIsExercisedInDryRun(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	r, err := NewRecorder(10)
+	is.NoErr(err)
+
+	locator := &services.EntityLocatorMock{
+		LocateFunc: func(ctx context.Context, entityType, entityID string) (float64, float64, error) {
+			return 62.388178, 17.315090, nil
+		},
+	}
+
+	app := application.NewApplication(ctx, r.Reporter(), locator, application.WithResolver(r.Resolver()))
+
+	for _, status := range []string{"off", "off", "on"} {
+		is.NoErr(app.LifebuoyValueUpdated(ctx, "default", "urn:ngsi-ld:Lifebuoy:elt-livboj-01", status))
+	}
+
+	records := r.Query(Filter{})
+	is.Equal(len(records), 2)
+	is.Equal(records[0].Action, ActionReport)
+	is.Equal(records[0].Key, "elt-livboj-01:value")
+	is.Equal(records[0].Incident.Category, 15)
+	is.Equal(records[1].Action, ActionResolve)
+	is.Equal(records[1].IncidentID, "DRYRUN_1")
+}
@@ -0,0 +1,155 @@
+package locale
+
+import (
+	"embed"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultLanguage is the language used unless another one is selected, and
+// the language that texts missing from other catalogs are taken from
+const DefaultLanguage string = "sv"
+
+//go:embed catalogs/*.yaml
+var shipped embed.FS
+
+// Catalog holds the translations of the status messages reported by devices,
+// keyed by the untranslated message, and the texts written by the service
+// itself, keyed by name.
+type Catalog struct {
+	Language string            `yaml:"-" json:"language"`
+	Statuses map[string]string `yaml:"status" json:"status"`
+	Texts    map[string]string `yaml:"text" json:"text"`
+
+	fallback *Catalog
+}
+
+// Catalogs holds a catalog per language
+type Catalogs map[string]*Catalog
+
+// Default returns the catalogs shipped with the service, in Swedish and English
+func Default() Catalogs {
+	c := Catalogs{}
+
+	entries, _ := shipped.ReadDir("catalogs")
+	for _, e := range entries {
+		b, _ := shipped.ReadFile("catalogs/" + e.Name())
+
+		err := c.add(strings.TrimSuffix(e.Name(), ".yaml"), b)
+		if err != nil {
+			panic(fmt.Sprintf("shipped catalog %s is invalid: %s", e.Name(), err.Error()))
+		}
+	}
+
+	return c.link()
+}
+
+// Load adds the catalogs found in dir, one file per language named after the
+// language such as sv.yaml, to the shipped catalogs. Entries in a file replace
+// those shipped for the same language.
+func Load(dir string) (Catalogs, error) {
+	c := Default()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list locale catalogs: %w", err)
+	}
+
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read locale catalog: %w", err)
+		}
+
+		err = c.add(strings.TrimSuffix(filepath.Base(f), ".yaml"), b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.link(), nil
+}
+
+func (c Catalogs) add(language string, b []byte) error {
+	catalog := &Catalog{}
+
+	err := yaml.Unmarshal(b, catalog)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal locale catalog %s: %w", language, err)
+	}
+
+	existing, ok := c[language]
+	if !ok {
+		existing = &Catalog{Language: language, Statuses: map[string]string{}, Texts: map[string]string{}}
+		c[language] = existing
+	}
+
+	maps.Copy(existing.Statuses, catalog.Statuses)
+	maps.Copy(existing.Texts, catalog.Texts)
+
+	return nil
+}
+
+// link lets the catalogs fall back on the default language for missing texts
+func (c Catalogs) link() Catalogs {
+	for language, catalog := range c {
+		if language != DefaultLanguage {
+			catalog.fallback = c[DefaultLanguage]
+		}
+	}
+	return c
+}
+
+// Languages returns the available languages in sorted order
+func (c Catalogs) Languages() []string {
+	return slices.Sorted(maps.Keys(c))
+}
+
+// Get returns the catalog for a language, or for the default language if the
+// language is empty
+func (c Catalogs) Get(language string) (*Catalog, error) {
+	if language == "" {
+		language = DefaultLanguage
+	}
+
+	catalog, ok := c[language]
+	if !ok {
+		return nil, fmt.Errorf("no locale catalog for language \"%s\", available languages are %s", language, strings.Join(c.Languages(), ", "))
+	}
+
+	return catalog, nil
+}
+
+// Status translates a status message. A message that is missing from the
+// catalog is taken from the default language, and messages without any
+// translation are returned as they are.
+func (c *Catalog) Status(message string) string {
+	for catalog := c; catalog != nil; catalog = catalog.fallback {
+		if s, ok := catalog.Statuses[message]; ok {
+			return s
+		}
+	}
+	return message
+}
+
+// Textf formats the named text with the given arguments. A text that is
+// missing from the catalog is taken from the default language.
+func (c *Catalog) Textf(name string, args ...any) string {
+	return fmt.Sprintf(c.Text(name), args...)
+}
+
+// Text returns the named text, or the name itself if no catalog has it
+func (c *Catalog) Text(name string) string {
+	for catalog := c; catalog != nil; catalog = catalog.fallback {
+		if s, ok := catalog.Texts[name]; ok {
+			return s
+		}
+	}
+	return name
+}
@@ -0,0 +1,53 @@
+package locale
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestThatSwedishAndEnglishAreShipped(t *testing.T) {
+	is := is.New(t)
+
+	c := Default()
+	is.Equal(c.Languages(), []string{"en", "sv"})
+
+	sv, err := c.Get("")
+	is.NoErr(err)
+	is.Equal(sv.Status("Leak"), "Läckage")
+	is.Equal(sv.Status("Something new"), "Something new")
+	is.Equal(sv.Textf("reminder", "Bräddning", 2), "Bräddning (påminnelse 2)")
+
+	en, err := c.Get("en")
+	is.NoErr(err)
+	is.Equal(en.Status("Freeze"), "Ice or freeze warning")
+	is.Equal(en.Textf("reminder", "Overflow", 1), "Overflow (reminder 1)")
+
+	_, err = c.Get("fi")
+	is.True(err != nil)
+}
+
+func TestThatCatalogsCanBeAddedAndExtended(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(dir, "sv.yaml"), []byte("status:\n  Leak: Läcka\n"), 0o644))
+	is.NoErr(os.WriteFile(filepath.Join(dir, "fi.yaml"), []byte("status:\n  Leak: Vuoto\n"), 0o644))
+
+	c, err := Load(dir)
+	is.NoErr(err)
+
+	sv, _ := c.Get("sv")
+	is.Equal(sv.Status("Leak"), "Läcka")
+	is.Equal(sv.Status("Burst"), "Spricka")
+
+	fi, err := c.Get("fi")
+	is.NoErr(err)
+	is.Equal(fi.Status("Leak"), "Vuoto")
+	// statuses missing from a catalog are taken from the default language
+	is.Equal(fi.Status("Burst"), "Spricka")
+	// texts missing from a catalog are taken from the default language
+	is.Equal(fi.Textf("reminder", "Vuoto", 1), "Vuoto (påminnelse 1)")
+}
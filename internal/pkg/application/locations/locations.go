@@ -0,0 +1,48 @@
+package locations
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Point struct {
+	Latitude  float64 `yaml:"latitude" json:"latitude"`
+	Longitude float64 `yaml:"longitude" json:"longitude"`
+}
+
+// Overrides holds manually entered locations keyed by device or entity id,
+// for devices that can not be located through the context broker.
+type Overrides map[string]Point
+
+func Load(path string) (Overrides, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read location overrides: %w", err)
+	}
+
+	o := Overrides{}
+	err = yaml.Unmarshal(b, &o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal location overrides: %w", err)
+	}
+
+	for id, p := range o {
+		if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+			return nil, fmt.Errorf("invalid location override for %s", id)
+		}
+	}
+
+	return o, nil
+}
+
+// Lookup returns the first override found for any of the given ids
+func (o Overrides) Lookup(ids ...string) (Point, bool) {
+	for _, id := range ids {
+		if p, ok := o[id]; ok {
+			return p, true
+		}
+	}
+	return Point{}, false
+}
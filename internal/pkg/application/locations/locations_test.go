@@ -0,0 +1,42 @@
+package locations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestLoadAndLookupOverrides(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "locations.yaml")
+	is.NoErr(os.WriteFile(path, []byte(overrides), 0644))
+
+	o, err := Load(path)
+	is.NoErr(err)
+
+	p, ok := o.Lookup("urn:ngsi-ld:Device:se:servanet:lora:msva:1234", "1234")
+	is.True(ok)
+	is.Equal(p, Point{Latitude: 62.39, Longitude: 17.31})
+
+	_, ok = o.Lookup("5678")
+	is.True(!ok)
+}
+
+func TestLoadRejectsInvalidCoordinates(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "locations.yaml")
+	is.NoErr(os.WriteFile(path, []byte("\"1234\": {latitude: 162.39, longitude: 17.31}\n"), 0644))
+
+	_, err := Load(path)
+	is.True(err != nil)
+}
+
+const overrides string = `
+"1234":
+  latitude: 62.39
+  longitude: 17.31
+`
@@ -0,0 +1,96 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var outboxBucket = []byte("outbox")
+
+type fileStore struct {
+	db *bolt.DB
+}
+
+// NewFileStore opens, or creates, a bbolt database at path. Entries are
+// listed in the order of their ids.
+func NewFileStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox file %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outboxBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create outbox bucket: %w", err)
+	}
+
+	return &fileStore{db: db}, nil
+}
+
+func (s *fileStore) Put(_ context.Context, entry Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox entry: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Put([]byte(entry.ID), b)
+	})
+}
+
+func (s *fileStore) Get(_ context.Context, id string) (Entry, bool, error) {
+	var b []byte
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(outboxBucket).Get([]byte(id)); v != nil {
+			b = append(b, v...)
+		}
+		return nil
+	})
+	if err != nil || b == nil {
+		return Entry{}, false, err
+	}
+
+	e := Entry{}
+	err = json.Unmarshal(b, &e)
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("failed to unmarshal outbox entry %s: %w", id, err)
+	}
+
+	return e, true, nil
+}
+
+func (s *fileStore) Delete(_ context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete([]byte(id))
+	})
+}
+
+func (s *fileStore) List(_ context.Context) ([]Entry, error) {
+	entries := []Entry{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			e := Entry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("failed to unmarshal outbox entry %s: %w", k, err)
+			}
+			entries = append(entries, e)
+			return nil
+		})
+	})
+
+	return entries, err
+}
+
+func (s *fileStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type memoryStore struct {
+	mx      sync.Mutex
+	entries map[string]Entry
+}
+
+func NewInMemoryStore() Store {
+	return &memoryStore{entries: make(map[string]Entry)}
+}
+
+func (s *memoryStore) Put(_ context.Context, entry Entry) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.entries[entry.ID] = entry
+	return nil
+}
+
+func (s *memoryStore) Get(_ context.Context, id string) (Entry, bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	e, ok := s.entries[id]
+	return e, ok, nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, id string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context) ([]Entry, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+
+	slices.SortFunc(entries, func(a, b Entry) int { return strings.Compare(a.ID, b.ID) })
+
+	return entries, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,324 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("integration-incident/outbox")
+
+var ErrNotFound = errors.New("outbox entry not found")
+
+// PostedFunc is called with the dedup key and the id of the created incident
+// once an entry has been posted
+type PostedFunc func(ctx context.Context, key, incidentID string) error
+
+// Outbox stores incidents before they are posted so that they are not lost
+// when the incident API is unavailable. Stored incidents are posted by Run.
+// Failed posts are retried with exponential backoff and jitter. An entry that
+// is rejected by the incident API, or that has failed the maximum number of
+// attempts, is kept as a dead letter.
+type Outbox struct {
+	mx       sync.Mutex
+	store    Store
+	reporter incident.CreatorFunc
+	cfg      config
+	wake     chan struct{}
+	now      func() time.Time
+}
+
+type config struct {
+	pollInterval   time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxAttempts    int
+}
+
+type Option func(*config)
+
+// WithPollInterval sets how often the store is checked for entries that are
+// due to be retried
+func WithPollInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.pollInterval = d
+	}
+}
+
+// WithBackoff sets the delay before the first retry and the longest delay
+// between two attempts. The delay is doubled after each failed attempt.
+func WithBackoff(initial, max time.Duration) Option {
+	return func(c *config) {
+		c.initialBackoff = initial
+		c.maxBackoff = max
+	}
+}
+
+// WithMaxAttempts sets the number of attempts after which an entry is moved
+// to the dead letters
+func WithMaxAttempts(attempts int) Option {
+	return func(c *config) {
+		c.maxAttempts = attempts
+	}
+}
+
+func New(store Store, reporter incident.CreatorFunc, opts ...Option) (*Outbox, error) {
+	cfg := config{
+		pollInterval:   5 * time.Second,
+		initialBackoff: 10 * time.Second,
+		maxBackoff:     time.Hour,
+		maxAttempts:    20,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.pollInterval <= 0 || cfg.initialBackoff <= 0 || cfg.maxBackoff < cfg.initialBackoff {
+		return nil, fmt.Errorf("invalid outbox intervals")
+	}
+
+	if cfg.maxAttempts < 1 {
+		return nil, fmt.Errorf("outbox requires at least one attempt")
+	}
+
+	return &Outbox{
+		store:    store,
+		reporter: reporter,
+		cfg:      cfg,
+		wake:     make(chan struct{}, 1),
+		now:      func() time.Time { return time.Now().UTC() },
+	}, nil
+}
+
+// Reporter returns a CreatorFunc that stores the incident in the outbox. As
+// the incident is posted later, the returned incident id is always empty.
+func (o *Outbox) Reporter() incident.CreatorFunc {
+	return func(ctx context.Context, inc models.Incident) (string, error) {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return "", fmt.Errorf("failed to create outbox id: %w", err)
+		}
+
+		now := o.now()
+
+		err = o.store.Put(ctx, Entry{
+			ID:          id.String(),
+			Key:         inc.Key,
+			Tenant:      inc.Tenant,
+			Incident:    inc,
+			Created:     now,
+			NextAttempt: now,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to store incident in outbox: %w", err)
+		}
+
+		logging.GetFromContext(ctx).Debug("incident added to outbox", "outbox_id", id.String(), "key", inc.Key)
+
+		o.notify()
+
+		return "", nil
+	}
+}
+
+// Run posts the entries of the outbox until the context is cancelled
+func (o *Outbox) Run(ctx context.Context, posted PostedFunc) {
+	ticker := time.NewTicker(o.cfg.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.process(ctx, posted)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// List returns all entries, including the dead letters
+func (o *Outbox) List(ctx context.Context) ([]Entry, error) {
+	return o.store.List(ctx)
+}
+
+// Retry makes an entry, dead or not, due to be posted right away
+func (o *Outbox) Retry(ctx context.Context, id string) error {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	e, ok, err := o.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+
+	e.Dead = false
+	e.Attempts = 0
+	e.NextAttempt = o.now()
+
+	err = o.store.Put(ctx, e)
+	if err != nil {
+		return err
+	}
+
+	o.notify()
+
+	return nil
+}
+
+// Discard removes an entry without posting it
+func (o *Outbox) Discard(ctx context.Context, id string) error {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	_, ok, err := o.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+
+	return o.store.Delete(ctx, id)
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) process(ctx context.Context, posted PostedFunc) {
+	log := logging.GetFromContext(ctx)
+
+	entries, err := o.store.List(ctx)
+	if err != nil {
+		log.Error("failed to list outbox entries", "err", err.Error())
+		return
+	}
+
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if e.Dead || e.NextAttempt.After(o.now()) {
+			continue
+		}
+
+		o.post(ctx, e.ID, posted)
+	}
+}
+
+// post posts a single entry. The lock is only held while the store is read
+// and updated, so that retrying and discarding entries does not have to wait
+// for the incident API.
+func (o *Outbox) post(ctx context.Context, id string, posted PostedFunc) {
+	var err error
+
+	ctx, span := tracer.Start(ctx, "post-incident")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	_, ctx, log := o11y.AddTraceIDToLoggerAndStoreInContext(span, logging.GetFromContext(ctx), ctx)
+
+	// the entry may have been retried or discarded since it was listed
+	o.mx.Lock()
+	e, ok, err := o.store.Get(ctx, id)
+	o.mx.Unlock()
+
+	if err != nil || !ok || e.Dead {
+		return
+	}
+
+	// the key and tenant are not part of the stored incident
+	e.Incident.Key, e.Incident.Tenant = e.Key, e.Tenant
+
+	incidentID, err := o.reporter(ctx, e.Incident)
+	if err != nil {
+		o.failed(ctx, e.ID, err)
+		return
+	}
+
+	log.Info("incident posted", "outbox_id", e.ID, "key", e.Key, "incident_id", incidentID)
+
+	o.mx.Lock()
+	err = o.store.Delete(ctx, e.ID)
+	o.mx.Unlock()
+
+	if err != nil {
+		log.Error("failed to remove posted incident from outbox", "outbox_id", e.ID, "err", err.Error())
+	}
+
+	if posted != nil && e.Key != "" && incidentID != "" {
+		err = posted(ctx, e.Key, incidentID)
+		if err != nil {
+			log.Error("failed to record posted incident", "key", e.Key, "incident_id", incidentID, "err", err.Error())
+		}
+	}
+}
+
+// failed records a failed attempt on the entry as it is stored now, unless it
+// has been discarded in the meantime. Incidents that were held back by the rate
+// limit or an open circuit breaker were never sent, and do not count as
+// attempts.
+func (o *Outbox) failed(ctx context.Context, id string, err error) {
+	log := logging.GetFromContext(ctx)
+
+	o.mx.Lock()
+	defer o.mx.Unlock()
+
+	e, ok, getErr := o.store.Get(ctx, id)
+	if getErr != nil || !ok {
+		return
+	}
+
+	e.LastError = err.Error()
+
+	if errors.Is(err, incident.ErrRateLimited) || errors.Is(err, incident.ErrCircuitOpen) {
+		e.NextAttempt = o.now().Add(o.backoff(1))
+		log.Debug("incident held back", "outbox_id", e.ID, "key", e.Key, "next_attempt", e.NextAttempt, "err", err.Error())
+	} else {
+		e.Attempts++
+
+		if incident.IsPermanent(err) || e.Attempts >= o.cfg.maxAttempts {
+			e.Dead = true
+			log.Error("giving up on incident", "outbox_id", e.ID, "key", e.Key, "attempts", e.Attempts, "err", err.Error())
+		} else {
+			e.NextAttempt = o.now().Add(o.backoff(e.Attempts))
+			log.Warn("failed to post incident", "outbox_id", e.ID, "key", e.Key, "attempts", e.Attempts, "next_attempt", e.NextAttempt, "err", err.Error())
+		}
+	}
+
+	if putErr := o.store.Put(ctx, e); putErr != nil {
+		log.Error("failed to update outbox entry", "outbox_id", e.ID, "err", putErr.Error())
+	}
+}
+
+// backoff returns the delay before the next attempt, picked at random between
+// half and all of the exponential delay so that retries are spread out
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.cfg.initialBackoff
+	for i := 1; i < attempts && d < o.cfg.maxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, o.cfg.maxBackoff)
+
+	return d/2 + rand.N(d/2+1)
+}
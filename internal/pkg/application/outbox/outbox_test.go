@@ -0,0 +1,222 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/matryer/is"
+)
+
+func TestThatPostedIncidentIsRemovedAndRecorded(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	o, _ := newTestOutbox(is, NewInMemoryStore(), nil)
+
+	_, err := o.Reporter()(ctx, *incidentWithKey("devId1:state"))
+	is.NoErr(err)
+
+	recorded := map[string]string{}
+	o.process(ctx, func(_ context.Context, key, incidentID string) error {
+		recorded[key] = incidentID
+		return nil
+	})
+
+	is.Equal(recorded["devId1:state"], "SP_1")
+
+	entries, _ := o.List(ctx)
+	is.Equal(len(entries), 0)
+}
+
+func TestThatFailedIncidentIsRetriedAndThenDeadLettered(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	o, now := newTestOutbox(is, NewInMemoryStore(), errors.New("gateway unavailable"))
+
+	_, err := o.Reporter()(ctx, *incidentWithKey("devId1:state"))
+	is.NoErr(err)
+
+	o.process(ctx, nil)
+
+	entries, _ := o.List(ctx)
+	is.Equal(len(entries), 1)
+	is.Equal(entries[0].Attempts, 1)
+	is.Equal(entries[0].LastError, "gateway unavailable")
+	is.True(entries[0].NextAttempt.After(*now))
+
+	// not due yet
+	o.process(ctx, nil)
+	entries, _ = o.List(ctx)
+	is.Equal(entries[0].Attempts, 1)
+
+	*now = now.Add(time.Minute)
+	o.process(ctx, nil)
+	*now = now.Add(time.Minute)
+	o.process(ctx, nil)
+
+	entries, _ = o.List(ctx)
+	is.Equal(entries[0].Attempts, 3)
+	is.True(entries[0].Dead)
+
+	// dead letters are left alone until they are retried
+	*now = now.Add(time.Hour)
+	o.process(ctx, nil)
+	entries, _ = o.List(ctx)
+	is.Equal(entries[0].Attempts, 3)
+
+	is.NoErr(o.Retry(ctx, entries[0].ID))
+	entries, _ = o.List(ctx)
+	is.True(!entries[0].Dead)
+	is.Equal(entries[0].Attempts, 0)
+
+	is.NoErr(o.Discard(ctx, entries[0].ID))
+	entries, _ = o.List(ctx)
+	is.Equal(len(entries), 0)
+
+	is.True(errors.Is(o.Discard(ctx, "unknown"), ErrNotFound))
+}
+
+func TestThatRejectedIncidentIsDeadLetteredRightAway(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	o, _ := newTestOutbox(is, NewInMemoryStore(), &incident.RejectedError{StatusCode: 400, Message: "invalid category"})
+
+	_, err := o.Reporter()(ctx, *incidentWithKey("devId1:state"))
+	is.NoErr(err)
+
+	o.process(ctx, nil)
+
+	entries, _ := o.List(ctx)
+	is.Equal(entries[0].Attempts, 1)
+	is.True(entries[0].Dead)
+}
+
+func TestThatHeldBackIncidentsDoNotCountAsAttempts(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	o, now := newTestOutbox(is, NewInMemoryStore(), incident.ErrCircuitOpen)
+
+	_, err := o.Reporter()(ctx, *incidentWithKey("devId1:state"))
+	is.NoErr(err)
+
+	for range 5 {
+		o.process(ctx, nil)
+		*now = now.Add(time.Minute)
+	}
+
+	entries, _ := o.List(ctx)
+	is.Equal(entries[0].Attempts, 0)
+	is.True(!entries[0].Dead)
+}
+
+func TestThatEntryCanBeDiscardedWhilePosting(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	posting := make(chan struct{})
+	release := make(chan struct{})
+
+	o, err := New(NewInMemoryStore(), func(context.Context, models.Incident) (string, error) {
+		close(posting)
+		<-release
+		return "", errors.New("gateway unavailable")
+	})
+	is.NoErr(err)
+
+	_, err = o.Reporter()(ctx, *incidentWithKey("devId1:state"))
+	is.NoErr(err)
+
+	done := make(chan struct{})
+	go func() {
+		o.process(ctx, nil)
+		close(done)
+	}()
+
+	<-posting
+	entries, _ := o.List(ctx)
+	is.NoErr(o.Discard(ctx, entries[0].ID))
+	close(release)
+	<-done
+
+	// the failed attempt does not bring the discarded entry back
+	entries, _ = o.List(ctx)
+	is.Equal(len(entries), 0)
+}
+
+func TestThatBackoffGrowsUpToMax(t *testing.T) {
+	is := is.New(t)
+
+	o, err := New(NewInMemoryStore(), nil, WithBackoff(time.Second, 10*time.Second))
+	is.NoErr(err)
+
+	for attempts, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 10 * time.Second} {
+		d := o.backoff(attempts)
+		is.True(d >= max/2 && d <= max)
+	}
+}
+
+func TestThatFileStoreKeepsEntriesAcrossRestarts(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.db")
+
+	s, err := NewStore(TypeFile, path)
+	is.NoErr(err)
+
+	o, _ := newTestOutbox(is, s, nil)
+	_, err = o.Reporter()(ctx, *incidentWithKey("devId1:state"))
+	is.NoErr(err)
+	_, err = o.Reporter()(ctx, *incidentWithKey("devId2:state"))
+	is.NoErr(err)
+	is.NoErr(s.Close())
+
+	s, err = NewStore(TypeFile, path)
+	is.NoErr(err)
+	defer s.Close()
+
+	entries, err := s.List(ctx)
+	is.NoErr(err)
+	is.Equal(len(entries), 2)
+	is.Equal(entries[0].Key, "devId1:state")
+	is.Equal(entries[1].Incident.Description, "a description")
+}
+
+func TestUnknownStoreType(t *testing.T) {
+	is := is.New(t)
+
+	_, err := NewStore("redis", "")
+	is.True(err != nil)
+}
+
+func newTestOutbox(is *is.I, s Store, postErr error) (*Outbox, *time.Time) {
+	posted := 0
+
+	o, err := New(s, func(context.Context, models.Incident) (string, error) {
+		if postErr != nil {
+			return "", postErr
+		}
+		posted++
+		return "SP_" + strconv.Itoa(posted), nil
+	}, WithBackoff(10*time.Second, 30*time.Second), WithMaxAttempts(3))
+	is.NoErr(err)
+
+	now := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	o.now = func() time.Time { return now }
+
+	return o, &now
+}
+
+func incidentWithKey(key string) *models.Incident {
+	i := models.NewIncident(17, "a description")
+	i.Key = key
+	return i
+}
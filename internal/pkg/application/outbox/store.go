@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/diwise/integration-incident/pkg/models"
+)
+
+// Entry is an incident waiting to be posted. Key is the dedup key of the rule
+// that reported it and Tenant the tenant it is posted for. Entries that have
+// been given up on are kept as dead letters, with Dead set, until they are
+// retried or discarded.
+type Entry struct {
+	ID          string          `json:"id"`
+	Key         string          `json:"key"`
+	Tenant      string          `json:"tenant,omitempty"`
+	Incident    models.Incident `json:"incident"`
+	Created     time.Time       `json:"created"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+	Dead        bool            `json:"dead"`
+}
+
+// Store keeps the entries of the outbox. List returns the entries in the
+// order they were added.
+type Store interface {
+	Put(ctx context.Context, entry Entry) error
+	Get(ctx context.Context, id string) (Entry, bool, error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]Entry, error)
+	Close() error
+}
+
+const (
+	TypeMemory string = "memory"
+	TypeFile   string = "file"
+)
+
+// NewStore creates a store of the given type. The path is only used by file
+// backed stores.
+func NewStore(storeType, path string) (Store, error) {
+	switch storeType {
+	case TypeMemory:
+		return NewInMemoryStore(), nil
+	case TypeFile:
+		return NewFileStore(path)
+	}
+
+	return nil, fmt.Errorf("unknown outbox store type \"%s\"", storeType)
+}
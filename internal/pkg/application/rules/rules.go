@@ -0,0 +1,750 @@
+package rules
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/seasons"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SourceStatusMessage   string = "statusmessage"
+	SourceNotification    string = "notification"
+	SourceFunctionUpdated string = "function.updated"
+)
+
+const (
+	ThresholdHigh   string = "high"
+	ThresholdLow    string = "low"
+	ThresholdNormal string = "normal"
+)
+
+const (
+	LocationNone     string = "none"
+	LocationFixed    string = "fixed"
+	LocationEntity   string = "entity"
+	LocationEvent    string = "event"
+	LocationOverride string = "override"
+)
+
+//go:embed default.yaml
+var defaultRules []byte
+
+// Event is the normalised form of everything this service receives. Rules
+// only ever look at events, never at the messages they were created from.
+type Event struct {
+	Source     string            `json:"source"`
+	Type       string            `json:"type,omitempty"`
+	SubType    string            `json:"subType,omitempty"`
+	ID         string            `json:"id"`
+	Location   *Location         `json:"location,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Property returns the named property of the event. The id, type and subType
+// of the event itself are available as properties too.
+func (e Event) Property(name string) string {
+	switch name {
+	case "id":
+		return e.ID
+	case "type":
+		return e.Type
+	case "subType":
+		return e.SubType
+	}
+	return e.Properties[name]
+}
+
+// With returns a copy of the event with the named property set
+func (e Event) With(name, value string) Event {
+	p := maps.Clone(e.Properties)
+	if p == nil {
+		p = map[string]string{}
+	}
+	p[name] = value
+	e.Properties = p
+	return e
+}
+
+type RuleSet struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+type Rule struct {
+	ID        string      `yaml:"id"`
+	Kind      string      `yaml:"kind"`
+	Input     Input       `yaml:"input"`
+	Filter    []Condition `yaml:"filter,omitempty"`
+	Trigger   []Condition `yaml:"trigger,omitempty"`
+	Window    *Window     `yaml:"window,omitempty"`
+	Baseline  *Baseline   `yaml:"baseline,omitempty"`
+	Silence   *Silence    `yaml:"silence,omitempty"`
+	Timer     *Timer      `yaml:"timer,omitempty"`
+	Threshold *Threshold  `yaml:"threshold,omitempty"`
+	Dedup     Dedup       `yaml:"dedup"`
+	Reminder  *Reminder   `yaml:"reminder,omitempty"`
+	Resolve   *Resolve    `yaml:"resolve,omitempty"`
+	Incident  Template    `yaml:"incident"`
+}
+
+type Input struct {
+	Source  string `yaml:"source"`
+	Type    string `yaml:"type,omitempty"`
+	SubType string `yaml:"subType,omitempty"`
+}
+
+// Window gives how much a cumulative property has grown within Duration as window.count
+type Window struct {
+	Property string        `yaml:"property"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Silence gives the last event of a source again as silent once it has been silent for After
+type Silence struct {
+	After time.Duration `yaml:"after"`
+}
+
+// Timer gives the time a timer has run as timer.elapsed, and sets timer.overrun after Max
+type Timer struct {
+	Max time.Duration `yaml:"max"`
+}
+
+// Threshold classifies a numeric property as high, low or normal. Sources holds
+// limits of their own by event id.
+type Threshold struct {
+	Property   string            `yaml:"property"`
+	High       *float64          `yaml:"high,omitempty"`
+	Low        *float64          `yaml:"low,omitempty"`
+	Hysteresis float64           `yaml:"hysteresis,omitempty"`
+	Sources    map[string]Limits `yaml:"sources,omitempty"`
+}
+
+// Limits replaces the limits of a threshold for a single source
+type Limits struct {
+	High *float64 `yaml:"high,omitempty"`
+	Low  *float64 `yaml:"low,omitempty"`
+}
+
+// Baseline gives the deviation of a property from its mean over the last Periods periods
+type Baseline struct {
+	Property string        `yaml:"property"`
+	Period   time.Duration `yaml:"period"`
+	Periods  int           `yaml:"periods"`
+}
+
+// Dedup names the key and value that are stored for an event
+type Dedup struct {
+	Key   string        `yaml:"key"`
+	Value string        `yaml:"value"`
+	TTL   time.Duration `yaml:"ttl,omitempty"`
+}
+
+// Reminder reports the incident again after After, at most Limit times if Limit is set
+type Reminder struct {
+	After time.Duration `yaml:"after"`
+	Limit int           `yaml:"limit,omitempty"`
+}
+
+// Resolve closes the reported incidents, with Note as resolution note, once
+// the rule is no longer triggered for the dedup key.
+type Resolve struct {
+	Note string `yaml:"note"`
+}
+
+type Template struct {
+	Category    int             `yaml:"category,omitempty"`
+	Description string          `yaml:"description"`
+	Location    LocationSetting `yaml:"location"`
+}
+
+// LocationSetting names where the location of an incident is taken from. The
+// sources in Fallback are tried in order if the primary source has no location.
+type LocationSetting struct {
+	Source     string   `yaml:"source"`
+	Fallback   []string `yaml:"fallback,omitempty"`
+	EntityType string   `yaml:"entityType,omitempty"`
+	Latitude   float64  `yaml:"latitude,omitempty"`
+	Longitude  float64  `yaml:"longitude,omitempty"`
+}
+
+func (l LocationSetting) Sources() []string {
+	if l.Source == "" {
+		return l.Fallback
+	}
+	return append([]string{l.Source}, l.Fallback...)
+}
+
+// Condition holds exactly one operator that is applied to the named property
+// of an event, or a list of alternatives of which at least one must hold.
+type Condition struct {
+	Property    string          `yaml:"property,omitempty"`
+	Equals      *string         `yaml:"equals,omitempty"`
+	NotEquals   *string         `yaml:"notEquals,omitempty"`
+	In          []string        `yaml:"in,omitempty"`
+	NotIn       []string        `yaml:"notIn,omitempty"`
+	Contains    string          `yaml:"contains,omitempty"`
+	ContainsAny []string        `yaml:"containsAny,omitempty"`
+	Prefix      string          `yaml:"prefix,omitempty"`
+	Months      []int           `yaml:"months,omitempty"`
+	Within      *Hours          `yaml:"within,omitempty"`
+	Outside     *Hours          `yaml:"outside,omitempty"`
+	Season      *seasons.Season `yaml:"season,omitempty"`
+	AnyOf       []Condition     `yaml:"anyOf,omitempty"`
+}
+
+// Hours are the hours from From to To on the given days, or every day if there are none.
+// Hours that end before they start span midnight.
+type Hours struct {
+	Days     []string `yaml:"days,omitempty"`
+	From     string   `yaml:"from"`
+	To       string   `yaml:"to"`
+	TimeZone string   `yaml:"timeZone,omitempty"`
+
+	from, to int
+	location *time.Location
+}
+
+// Default returns the rules that are shipped with the service and used unless
+// a rules file is configured.
+func Default() *RuleSet {
+	rs, err := Parse(defaultRules)
+	if err != nil {
+		panic(fmt.Sprintf("default rules are invalid: %s", err.Error()))
+	}
+	return rs
+}
+
+func Load(path string) (*RuleSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rules file: %w", err)
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	return Parse(b)
+}
+
+// Parse decodes a rule set from YAML. As JSON is a subset of YAML, rule files
+// may be written in either format.
+func Parse(b []byte) (*RuleSet, error) {
+	rs := RuleSet{}
+
+	err := yaml.Unmarshal(b, &rs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rules: %w", err)
+	}
+
+	err = rs.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &rs, nil
+}
+
+func (rs *RuleSet) Validate() error {
+	var errs []error
+	ids := map[string]bool{}
+
+	for i, r := range rs.Rules {
+		if r.ID == "" {
+			errs = append(errs, fmt.Errorf("rule %d has no id", i))
+			continue
+		}
+
+		if ids[r.ID] {
+			errs = append(errs, fmt.Errorf("duplicate rule id %s", r.ID))
+		}
+		ids[r.ID] = true
+
+		if err := r.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", r.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateCategories makes sure that every rule either has a category of its
+// own or that a category can be found for its kind.
+func (rs *RuleSet) ValidateCategories(category func(kind string) (int, bool)) error {
+	var errs []error
+
+	for _, r := range rs.Rules {
+		if _, ok := category(r.Kind); ok {
+			continue
+		}
+		if r.Incident.Category == 0 {
+			errs = append(errs, fmt.Errorf("rule %s: no category found for kind \"%s\"", r.ID, r.Kind))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Select returns the rules that accept the event, in the order they were declared
+func (rs *RuleSet) Select(evt Event) []Rule {
+	selected := []Rule{}
+
+	for _, r := range rs.Rules {
+		if r.Accepts(evt) {
+			selected = append(selected, r)
+		}
+	}
+
+	return selected
+}
+
+// Find returns the rule with the given id
+func (rs *RuleSet) Find(id string) (Rule, bool) {
+	for _, r := range rs.Rules {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+func (r Rule) validate() error {
+	if !slices.Contains([]string{SourceStatusMessage, SourceNotification, SourceFunctionUpdated}, r.Input.Source) {
+		return fmt.Errorf("unknown input source \"%s\"", r.Input.Source)
+	}
+
+	if r.Dedup.Key == "" {
+		return fmt.Errorf("dedup key is required")
+	}
+
+	if r.Dedup.TTL < 0 {
+		return fmt.Errorf("dedup ttl can not be negative")
+	}
+
+	if r.Reminder != nil && (r.Reminder.After <= 0 || r.Reminder.Limit < 0) {
+		return fmt.Errorf("reminder requires a positive duration and a limit that is not negative")
+	}
+
+	if r.Incident.Description == "" {
+		return fmt.Errorf("incident description is required")
+	}
+
+	for _, src := range r.Incident.Location.Sources() {
+		switch src {
+		case LocationNone, LocationEntity, LocationEvent, LocationOverride:
+		case LocationFixed:
+			if r.Incident.Location.Latitude == 0 && r.Incident.Location.Longitude == 0 {
+				return fmt.Errorf("fixed location requires latitude and longitude")
+			}
+		default:
+			return fmt.Errorf("unknown location source \"%s\"", src)
+		}
+	}
+
+	if r.Window != nil && (r.Window.Property == "" || r.Window.Duration <= 0) {
+		return fmt.Errorf("window requires a property and a positive duration")
+	}
+
+	if r.Baseline != nil && (r.Baseline.Property == "" || r.Baseline.Period <= 0 || r.Baseline.Periods <= 0) {
+		return fmt.Errorf("baseline requires a property, a positive period and a positive number of periods")
+	}
+
+	if r.Silence != nil {
+		if r.Silence.After <= 0 {
+			return fmt.Errorf("silence requires a positive duration")
+		}
+		if r.Dedup.Value != "silent" {
+			return fmt.Errorf("a rule with a silence must use silent as dedup value")
+		}
+		if r.Threshold != nil || r.Window != nil || r.Baseline != nil {
+			return fmt.Errorf("silence can not be combined with a threshold, a window or a baseline")
+		}
+	}
+
+	if r.Timer != nil {
+		if r.Timer.Max <= 0 {
+			return fmt.Errorf("timer requires a positive max duration")
+		}
+		if r.Dedup.Value != "timer.overrun" {
+			return fmt.Errorf("a rule with a timer must use timer.overrun as dedup value")
+		}
+		if r.Silence != nil || r.Threshold != nil || r.Window != nil || r.Baseline != nil {
+			return fmt.Errorf("timer can not be combined with a silence, a threshold, a window or a baseline")
+		}
+	}
+
+	if r.Threshold != nil {
+		if err := r.Threshold.validate(); err != nil {
+			return err
+		}
+		if r.Dedup.Value != "threshold" {
+			return fmt.Errorf("a rule with a threshold must use threshold as dedup value")
+		}
+	}
+
+	for _, c := range append(slices.Clone(r.Filter), r.Trigger...) {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Accepts reports whether the event is of the rule's input type and passes all of its filters
+func (r Rule) Accepts(evt Event) bool {
+	if r.Input.Source != evt.Source {
+		return false
+	}
+
+	if r.Input.Type != "" && !strings.EqualFold(r.Input.Type, evt.Type) {
+		return false
+	}
+
+	if r.Input.SubType != "" && !strings.EqualFold(r.Input.SubType, evt.SubType) {
+		return false
+	}
+
+	return all(r.Filter, evt)
+}
+
+// Triggered reports whether an accepted event should result in an incident
+func (r Rule) Triggered(evt Event) bool {
+	return all(r.Trigger, evt)
+}
+
+// Classify returns a copy of the event with the threshold properties set
+func (r Rule) Classify(evt Event, previous string) (Event, bool) {
+	if r.Threshold == nil {
+		return evt, true
+	}
+
+	v, err := strconv.ParseFloat(evt.Property(r.Threshold.Property), 64)
+	if err != nil {
+		return evt, false
+	}
+
+	level, limit := r.Threshold.classify(v, previous, evt.ID)
+
+	limitValue := ""
+	if limit != nil {
+		limitValue = strconv.FormatFloat(*limit, 'f', -1, 64)
+	}
+
+	return evt.With("threshold", level).With("threshold.limit", limitValue), true
+}
+
+// Measure returns a copy of the event with the timer properties set, as they are at now
+func (r Rule) Measure(evt Event, now time.Time) (Event, bool) {
+	if r.Timer == nil {
+		return evt, true
+	}
+
+	start, err := time.Parse(time.RFC3339Nano, evt.Property("timer.startTime"))
+	if err != nil {
+		return evt, false
+	}
+
+	running := evt.Property("timer.state") == "true"
+
+	var elapsed time.Duration
+
+	switch {
+	case running:
+		elapsed = now.Sub(start)
+	case evt.Property("timer.duration") != "":
+		elapsed, _ = time.ParseDuration(evt.Property("timer.duration"))
+	case evt.Property("timer.endTime") != "":
+		end, err := time.Parse(time.RFC3339Nano, evt.Property("timer.endTime"))
+		if err == nil {
+			elapsed = end.Sub(start)
+		}
+	}
+
+	overrun := running && elapsed >= r.Timer.Max
+
+	return evt.With("timer.elapsed", elapsed.Round(time.Second).String()).With("timer.overrun", strconv.FormatBool(overrun)), true
+}
+
+func (t Threshold) classify(v float64, previous, id string) (string, *float64) {
+	high, low := t.limits(id)
+
+	if high != nil {
+		if v >= *high || (previous == ThresholdHigh && v > *high-t.Hysteresis) {
+			return ThresholdHigh, high
+		}
+	}
+
+	if low != nil {
+		if v <= *low || (previous == ThresholdLow && v < *low+t.Hysteresis) {
+			return ThresholdLow, low
+		}
+	}
+
+	return ThresholdNormal, nil
+}
+
+func (t Threshold) limits(id string) (*float64, *float64) {
+	if l, ok := t.Sources[id]; ok {
+		return l.High, l.Low
+	}
+	return t.High, t.Low
+}
+
+func (t Threshold) validate() error {
+	if t.Property == "" {
+		return fmt.Errorf("threshold requires a property")
+	}
+
+	if t.Hysteresis < 0 {
+		return fmt.Errorf("threshold on %s can not have a negative hysteresis", t.Property)
+	}
+
+	if err := t.validateLimits(t.High, t.Low); err != nil {
+		return err
+	}
+
+	for id, l := range t.Sources {
+		if err := t.validateLimits(l.High, l.Low); err != nil {
+			return fmt.Errorf("source %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+func (t Threshold) validateLimits(high, low *float64) error {
+	if high == nil && low == nil {
+		return fmt.Errorf("threshold on %s requires a high or a low limit", t.Property)
+	}
+
+	if high != nil && low != nil && *high-t.Hysteresis <= *low+t.Hysteresis {
+		return fmt.Errorf("threshold on %s must have its high limit above its low limit, with room for the hysteresis", t.Property)
+	}
+
+	return nil
+}
+
+func (r Rule) Key(evt Event) string {
+	return expand(r.Dedup.Key, evt)
+}
+
+func (r Rule) Value(evt Event) string {
+	return evt.Property(r.Dedup.Value)
+}
+
+// Description expands the properties of the event into the description of the rule
+func (r Rule) Description(evt Event, text func(string) string) string {
+	return expand(text(r.Incident.Description), evt)
+}
+
+// ResolutionNote expands the properties of the event into the resolution note
+// of the rule, which is looked up with text in the same way as the description
+func (r Rule) ResolutionNote(evt Event, text func(string) string) string {
+	if r.Resolve == nil {
+		return ""
+	}
+	return expand(text(r.Resolve.Note), evt)
+}
+
+func all(conditions []Condition, evt Event) bool {
+	for _, c := range conditions {
+		if !c.Holds(evt) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) Holds(evt Event) bool {
+	if len(c.AnyOf) > 0 {
+		for _, alt := range c.AnyOf {
+			if alt.Holds(evt) {
+				return true
+			}
+		}
+		return false
+	}
+
+	v := evt.Property(c.Property)
+
+	switch {
+	case c.Equals != nil:
+		return v == *c.Equals
+	case c.NotEquals != nil:
+		return v != *c.NotEquals
+	case len(c.In) > 0:
+		return slices.Contains(c.In, v)
+	case len(c.NotIn) > 0:
+		return !slices.Contains(c.NotIn, v)
+	case c.Contains != "":
+		return strings.Contains(v, c.Contains)
+	case len(c.ContainsAny) > 0:
+		return slices.ContainsFunc(c.ContainsAny, func(s string) bool { return strings.Contains(v, s) })
+	case c.Prefix != "":
+		return strings.HasPrefix(v, c.Prefix)
+	case len(c.Months) > 0:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return false
+		}
+		return slices.Contains(c.Months, int(t.Month()))
+	case c.Within != nil:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return err == nil && c.Within.contains(t)
+	case c.Outside != nil:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return err == nil && !c.Outside.contains(t)
+	case c.Season != nil:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return err == nil && c.Season.Contains(t)
+	}
+
+	return false
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func (h *Hours) contains(t time.Time) bool {
+	if h.location == nil {
+		return false
+	}
+
+	local := t.In(h.location)
+	minute := local.Hour()*60 + local.Minute()
+	day := local.Weekday()
+
+	if h.from <= h.to {
+		return minute >= h.from && minute < h.to && h.on(day)
+	}
+
+	// hours that span midnight belong to the day they start on
+	if minute >= h.from {
+		return h.on(day)
+	}
+
+	return minute < h.to && h.on((day+6)%7)
+}
+
+func (h *Hours) on(day time.Weekday) bool {
+	return len(h.Days) == 0 || slices.Contains(h.Days, weekdays[day])
+}
+
+// compile checks the hours and prepares them for use. Conditions are copied
+// when rules are validated, but share their hours, which are compiled in place.
+func (h *Hours) compile() error {
+	var err error
+
+	h.from, err = minuteOfDay(h.From)
+	if err != nil {
+		return err
+	}
+
+	h.to, err = minuteOfDay(h.To)
+	if err != nil {
+		return err
+	}
+
+	if h.from == h.to {
+		return fmt.Errorf("hours from %s to %s are empty", h.From, h.To)
+	}
+
+	for _, d := range h.Days {
+		if !slices.Contains(weekdays, d) {
+			return fmt.Errorf("unknown day \"%s\", expected one of %s", d, strings.Join(weekdays, ", "))
+		}
+	}
+
+	tz := h.TimeZone
+	if tz == "" {
+		tz = "Europe/Stockholm"
+	}
+
+	h.location, err = time.LoadLocation(tz)
+	if err != nil {
+		return fmt.Errorf("invalid time zone %s: %w", tz, err)
+	}
+
+	return nil
+}
+
+func minuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("\"%s\" is not a time of day on the form HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (c Condition) validate() error {
+	if len(c.AnyOf) > 0 {
+		if c.Property != "" {
+			return fmt.Errorf("anyOf can not be combined with a property")
+		}
+		for _, alt := range c.AnyOf {
+			if err := alt.validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if c.Property == "" {
+		return fmt.Errorf("condition without property")
+	}
+
+	operators := 0
+	for _, set := range []bool{c.Equals != nil, c.NotEquals != nil, len(c.In) > 0, len(c.NotIn) > 0, c.Contains != "", len(c.ContainsAny) > 0, c.Prefix != "", len(c.Months) > 0, c.Within != nil, c.Outside != nil, c.Season != nil} {
+		if set {
+			operators++
+		}
+	}
+
+	if operators != 1 {
+		return fmt.Errorf("condition on %s must have exactly one operator, found %d", c.Property, operators)
+	}
+
+	for _, m := range c.Months {
+		if m < 1 || m > 12 {
+			return fmt.Errorf("condition on %s has invalid month %d", c.Property, m)
+		}
+	}
+
+	if c.Season != nil {
+		if err := c.Season.Validate(); err != nil {
+			return fmt.Errorf("condition on %s: %w", c.Property, err)
+		}
+	}
+
+	for _, h := range []*Hours{c.Within, c.Outside} {
+		if h == nil {
+			continue
+		}
+		if err := h.compile(); err != nil {
+			return fmt.Errorf("condition on %s: %w", c.Property, err)
+		}
+	}
+
+	return nil
+}
+
+func expand(s string, evt Event) string {
+	return os.Expand(s, evt.Property)
+}
@@ -0,0 +1,199 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/locale"
+	"github.com/matryer/is"
+)
+
+func TestDefaultRulesAreValid(t *testing.T) {
+	is := is.New(t)
+
+	rs := Default()
+	is.Equal(len(rs.Rules), 3)
+}
+
+func TestThatRulesWithoutCategoryAreReported(t *testing.T) {
+	is := is.New(t)
+
+	rs := Default()
+
+	err := rs.ValidateCategories(func(kind string) (int, bool) {
+		return 15, kind == "lifebuoy"
+	})
+	is.True(err != nil)
+
+	err = rs.ValidateCategories(func(kind string) (int, bool) {
+		return 15, true
+	})
+	is.NoErr(err)
+}
+
+func TestThatRuleAcceptsMatchingFunction(t *testing.T) {
+	is := is.New(t)
+
+	rs := Default()
+
+	evt := Event{
+		Source:     SourceFunctionUpdated,
+		Type:       "stopwatch",
+		SubType:    "overflow",
+		ID:         "fn-1",
+		Properties: map[string]string{"name": "Pumpstation 1", "stopwatch.state": "true"},
+	}
+
+	selected := rs.Select(evt)
+	is.Equal(len(selected), 1)
+
+	r := selected[0]
+	is.True(r.Triggered(evt))
+	is.Equal(r.Key(evt), "fn-1:stopwatch:overflow")
+	is.Equal(r.Value(evt), "true")
+	sv, _ := locale.Default().Get("sv")
+	is.Equal(r.Description(evt, sv.Text), "Bräddning upptäckt vid Pumpstation 1")
+
+	en, _ := locale.Default().Get("en")
+	is.Equal(r.Description(evt, en.Text), "Overflow detected at Pumpstation 1")
+	is.Equal(r.ResolutionNote(evt, en.Text), "The overflow at Pumpstation 1 has ended.")
+}
+
+func TestThatFreezeWarningIsFilteredOutsideOfSeason(t *testing.T) {
+	is := is.New(t)
+
+	rs := Default()
+
+	evt := Event{
+		Source: SourceStatusMessage,
+		ID:     "urn:ngsi-ld:Device:se:servanet:lora:msva:devId1",
+		Properties: map[string]string{
+			"deviceClass":  "watermeter",
+			"statusCode":   "1",
+			"messages":     "Freeze",
+			"freezeSeason": "false",
+		},
+	}
+
+	is.Equal(len(rs.Select(evt)), 0)
+
+	evt.Properties["freezeSeason"] = "true"
+	is.Equal(len(rs.Select(evt)), 1)
+}
+
+func TestThatThresholdIsKeptUntilTheValueHasMovedBackByTheHysteresis(t *testing.T) {
+	is := is.New(t)
+
+	high, low := 3.0, 0.5
+	r := Rule{Threshold: &Threshold{Property: "level.current", High: &high, Low: &low, Hysteresis: 0.25}}
+
+	classify := func(current, previous string) string {
+		evt, ok := r.Classify(Event{Properties: map[string]string{"level.current": current}}, previous)
+		is.True(ok)
+		return evt.Property("threshold")
+	}
+
+	is.Equal(classify("2.9", ThresholdNormal), ThresholdNormal)
+	is.Equal(classify("3.0", ThresholdNormal), ThresholdHigh)
+	is.Equal(classify("2.9", ThresholdHigh), ThresholdHigh)
+	is.Equal(classify("2.75", ThresholdHigh), ThresholdNormal)
+	is.Equal(classify("0.7", ThresholdLow), ThresholdLow)
+	is.Equal(classify("0.7", ThresholdNormal), ThresholdNormal)
+
+	_, ok := r.Classify(Event{Properties: map[string]string{}}, "")
+	is.True(!ok)
+}
+
+func TestThatHoursAreToldInTheirTimeZone(t *testing.T) {
+	is := is.New(t)
+
+	rs, err := Parse([]byte(`
+rules:
+  - id: night
+    input:
+      source: function.updated
+    filter:
+      - property: timestamp
+        within: {days: [fri], from: "22:00", to: "06:00"}
+    dedup:
+      key: "${id}"
+    incident:
+      description: "night"
+`))
+	is.NoErr(err)
+
+	at := func(timestamp string) Event {
+		return Event{Source: SourceFunctionUpdated, Properties: map[string]string{"timestamp": timestamp}}
+	}
+
+	// friday 2024-05-31, 22:30 in Stockholm
+	is.Equal(len(rs.Select(at("2024-05-31T20:30:00Z"))), 1)
+	// early saturday morning still belongs to friday night
+	is.Equal(len(rs.Select(at("2024-06-01T03:00:00Z"))), 1)
+	// but saturday night does not
+	is.Equal(len(rs.Select(at("2024-06-01T20:30:00Z"))), 0)
+	is.Equal(len(rs.Select(at("2024-05-31T12:00:00Z"))), 0)
+}
+
+func TestThatRulesCanBeParsedFromJSON(t *testing.T) {
+	is := is.New(t)
+
+	rs, err := Parse([]byte(jsonRules))
+	is.NoErr(err)
+	is.Equal(rs.Rules[0].Incident.Category, 12)
+}
+
+func TestThatInvalidRulesAreRejected(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte(invalidRules))
+	is.True(err != nil)
+}
+
+const jsonRules string = `{
+	"rules": [{
+		"id": "door",
+		"kind": "door",
+		"input": {"source": "function.updated", "type": "presence"},
+		"trigger": [{"property": "presence.state", "equals": "true"}],
+		"dedup": {"key": "${id}", "value": "presence.state"},
+		"incident": {"category": 12, "description": "Dörr öppnad vid ${name}", "location": {"source": "event"}}
+	}]
+}`
+
+func TestThatThresholdWithoutRoomForHysteresisIsRejected(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte(`
+rules:
+  - id: level
+    input:
+      source: function.updated
+    threshold:
+      property: level.percent
+      high: 20
+      low: 10
+      hysteresis: 5
+    dedup:
+      key: "${id}"
+      value: threshold
+    incident:
+      description: "level"
+`))
+	is.True(err != nil)
+}
+
+const invalidRules string = `
+rules:
+  - id: broken
+    input:
+      source: somethingelse
+    filter:
+      - property: status
+        equals: "off"
+        prefix: "o"
+    dedup:
+      key: "${id}"
+    incident:
+      category: 1
+      description: "broken"
+`
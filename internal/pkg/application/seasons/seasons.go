@@ -0,0 +1,177 @@
+package seasons
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	_ "time/tzdata"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTimeZone is used for seasons that do not name a time zone of their own
+const DefaultTimeZone string = "Europe/Stockholm"
+
+// Season is a range of days that recurs every year, given as month and day on
+// the form "10-01". Both days belong to the season, and a season that ends
+// before it starts spans new year. The day of a point in time is decided in
+// TimeZone, an IANA time zone name, so that an event just after midnight local
+// time belongs to the right day. A season must be validated before it is used.
+type Season struct {
+	From     string `yaml:"from" json:"from"`
+	To       string `yaml:"to" json:"to"`
+	TimeZone string `yaml:"timeZone,omitempty" json:"timeZone,omitempty"`
+
+	from, to int
+	location *time.Location
+}
+
+// Validate checks the days and time zone of the season and prepares it for use
+func (s *Season) Validate() error {
+	var err error
+
+	s.from, err = day(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid start of season: %w", err)
+	}
+
+	s.to, err = day(s.To)
+	if err != nil {
+		return fmt.Errorf("invalid end of season: %w", err)
+	}
+
+	tz := s.TimeZone
+	if tz == "" {
+		tz = DefaultTimeZone
+	}
+
+	s.location, err = time.LoadLocation(tz)
+	if err != nil {
+		return fmt.Errorf("invalid time zone %s: %w", tz, err)
+	}
+
+	return nil
+}
+
+// Contains reports whether t falls within the season
+func (s *Season) Contains(t time.Time) bool {
+	if s.location == nil {
+		return false
+	}
+
+	local := t.In(s.location)
+	d := int(local.Month())*100 + local.Day()
+
+	if s.from <= s.to {
+		return d >= s.from && d <= s.to
+	}
+
+	return d >= s.from || d <= s.to
+}
+
+func day(s string) (int, error) {
+	t, err := time.Parse("01-02", s)
+	if err != nil {
+		return 0, fmt.Errorf("\"%s\" is not a day on the form MM-DD", s)
+	}
+	return int(t.Month())*100 + t.Day(), nil
+}
+
+// Freeze is the season when freeze warnings from watermeters are reported.
+// Outside of the season warnings are reported as well while the temperature
+// observed by the Weather entity is below its threshold.
+type Freeze struct {
+	Season  `yaml:",inline"`
+	Weather *Weather `yaml:"weather,omitempty" json:"weather,omitempty"`
+}
+
+// Weather names a WeatherObserved entity and the temperature below which it
+// turns the freeze season on. Observations older than MaxAge are not used.
+type Weather struct {
+	Entity string        `yaml:"entity" json:"entity"`
+	Below  float64       `yaml:"below" json:"below"`
+	MaxAge time.Duration `yaml:"maxAge,omitempty" json:"maxAge,omitempty"`
+}
+
+// Observation is a temperature reported by a WeatherObserved entity
+type Observation struct {
+	Temperature float64
+	ObservedAt  time.Time
+}
+
+const defaultMaxAge time.Duration = 6 * time.Hour
+
+// DefaultFreeze returns the freeze season used unless one is configured, from
+// the first of October to the end of April in Swedish time
+func DefaultFreeze() *Freeze {
+	f, err := ParseFreeze([]byte(`{from: "10-01", to: "04-30"}`))
+	if err != nil {
+		panic(fmt.Sprintf("default freeze season is invalid: %s", err.Error()))
+	}
+	return f
+}
+
+func LoadFreeze(path string) (*Freeze, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read freeze season: %w", err)
+	}
+
+	return ParseFreeze(b)
+}
+
+// ParseFreeze decodes a freeze season from YAML (or JSON) and validates it
+func ParseFreeze(b []byte) (*Freeze, error) {
+	f := &Freeze{}
+
+	err := yaml.Unmarshal(b, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal freeze season: %w", err)
+	}
+
+	return f, f.Validate()
+}
+
+func (f *Freeze) Validate() error {
+	var errs []error
+
+	if err := f.Season.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if f.Weather != nil {
+		if f.Weather.Entity == "" {
+			errs = append(errs, fmt.Errorf("weather override requires an entity"))
+		}
+		if f.Weather.MaxAge < 0 {
+			errs = append(errs, fmt.Errorf("weather max age can not be negative"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Active reports whether freeze warnings are reported at t, either because t
+// is within the season or because the latest observation of the weather entity
+// is recent enough and below the threshold
+func (f *Freeze) Active(t time.Time, latest *Observation) bool {
+	if f.Contains(t) {
+		return true
+	}
+
+	if f.Weather == nil || latest == nil {
+		return false
+	}
+
+	maxAge := f.Weather.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultMaxAge
+	}
+
+	if t.Sub(latest.ObservedAt).Abs() > maxAge {
+		return false
+	}
+
+	return latest.Temperature < f.Weather.Below
+}
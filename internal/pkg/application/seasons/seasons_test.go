@@ -0,0 +1,64 @@
+package seasons
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestThatDefaultFreezeSeasonSpansNewYear(t *testing.T) {
+	is := is.New(t)
+
+	f := DefaultFreeze()
+
+	is.True(f.Active(time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC), nil))
+	is.True(f.Active(time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC), nil))
+	is.True(!f.Active(time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), nil))
+}
+
+func TestThatSeasonIsEvaluatedInItsTimeZone(t *testing.T) {
+	is := is.New(t)
+
+	f := DefaultFreeze()
+
+	// 23:30 UTC on the 30th of September is already October in Stockholm
+	is.True(f.Active(time.Date(2024, 9, 30, 23, 30, 0, 0, time.UTC), nil))
+	// and 22:30 UTC on the 30th of April is already May
+	is.True(!f.Active(time.Date(2024, 4, 30, 22, 30, 0, 0, time.UTC), nil))
+}
+
+func TestThatColdWeatherTurnsFreezeSeasonOn(t *testing.T) {
+	is := is.New(t)
+
+	f, err := ParseFreeze([]byte(`
+from: "10-15"
+to: "04-15"
+timeZone: Europe/Helsinki
+weather:
+  entity: urn:ngsi-ld:WeatherObserved:kiruna
+  below: 1.5
+  maxAge: 3h
+`))
+	is.NoErr(err)
+
+	now := time.Date(2024, 5, 20, 6, 0, 0, 0, time.UTC)
+
+	is.True(!f.Active(now, nil))
+	is.True(f.Active(now, &Observation{Temperature: -2, ObservedAt: now.Add(-time.Hour)}))
+	is.True(!f.Active(now, &Observation{Temperature: 4, ObservedAt: now.Add(-time.Hour)}))
+	is.True(!f.Active(now, &Observation{Temperature: -2, ObservedAt: now.Add(-4 * time.Hour)}))
+}
+
+func TestThatInvalidFreezeSeasonIsRejected(t *testing.T) {
+	is := is.New(t)
+
+	_, err := ParseFreeze([]byte(`{from: "13-01", to: "04-30"}`))
+	is.True(err != nil)
+
+	_, err = ParseFreeze([]byte(`{from: "10-01", to: "04-30", timeZone: Europe/Nowhere}`))
+	is.True(err != nil)
+
+	_, err = ParseFreeze([]byte(`{from: "10-01", to: "04-30", weather: {below: 0}}`))
+	is.True(err != nil)
+}
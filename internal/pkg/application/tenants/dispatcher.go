@@ -0,0 +1,126 @@
+package tenants
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/diwise/integration-incident/internal/pkg/application"
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// Dispatcher routes each event to the app of the tenant it belongs to, so
+// that one deployment can serve several tenants. Events for tenants that are
+// not configured, and that have no default tenant to go to, are dropped.
+type Dispatcher struct {
+	cfg  *Config
+	apps map[string]application.IntegrationIncident
+}
+
+func NewDispatcher(cfg *Config, apps map[string]application.IntegrationIncident) (*Dispatcher, error) {
+	for _, name := range cfg.Names() {
+		if _, ok := apps[name]; !ok {
+			return nil, fmt.Errorf("no app for tenant %s", name)
+		}
+	}
+
+	return &Dispatcher{cfg: cfg, apps: apps}, nil
+}
+
+func (d *Dispatcher) DeviceStateUpdated(ctx context.Context, deviceId string, sm models.StatusMessage) error {
+	app, ok := d.app(ctx, sm.Tenant, deviceId)
+	if !ok {
+		return nil
+	}
+	return app.DeviceStateUpdated(ctx, deviceId, sm)
+}
+
+func (d *Dispatcher) LifebuoyValueUpdated(ctx context.Context, tenant, deviceId, deviceValue string) error {
+	app, ok := d.app(ctx, tenant, deviceId)
+	if !ok {
+		return nil
+	}
+	return app.LifebuoyValueUpdated(ctx, tenant, deviceId, deviceValue)
+}
+
+func (d *Dispatcher) WeatherObserved(ctx context.Context, tenant, entityId string, temperature float64, observedAt time.Time) error {
+	app, ok := d.app(ctx, tenant, entityId)
+	if !ok {
+		return nil
+	}
+	return app.WeatherObserved(ctx, tenant, entityId, temperature, observedAt)
+}
+
+func (d *Dispatcher) SewageOverflowObserved(ctx context.Context, f models.FunctionUpdated) error {
+	app, ok := d.app(ctx, f.Tenant, f.Id)
+	if !ok {
+		return nil
+	}
+	return app.SewageOverflowObserved(ctx, f)
+}
+
+func (d *Dispatcher) FunctionUpdated(ctx context.Context, f models.FunctionUpdated) error {
+	app, ok := d.app(ctx, f.Tenant, f.Id)
+	if !ok {
+		return nil
+	}
+	return app.FunctionUpdated(ctx, f)
+}
+
+// IncidentCreated passes the id on to the tenant named by the prefix of the
+// dedup key, or to the default tenant if the key has no such prefix
+func (d *Dispatcher) IncidentCreated(ctx context.Context, key, incidentID string) error {
+	tenant, _, _ := strings.Cut(key, "/")
+
+	app, ok := d.apps[tenant]
+	if !ok {
+		app, ok = d.apps[d.cfg.Default]
+	}
+
+	if !ok {
+		return fmt.Errorf("no tenant for incident %s with key %s", incidentID, key)
+	}
+
+	return app.IncidentCreated(ctx, key, incidentID)
+}
+
+// Recheck checks the silent sources and running timers of every tenant
+func (d *Dispatcher) Recheck(ctx context.Context) error {
+	var errs []error
+
+	for _, name := range d.cfg.Names() {
+		if err := d.apps[name].Recheck(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d *Dispatcher) app(ctx context.Context, tenant, id string) (application.IntegrationIncident, bool) {
+	name, ok := d.cfg.Lookup(tenant)
+	if !ok {
+		logging.GetFromContext(ctx).Info("ignoring event for unknown tenant", "tenant", tenant, "id", id)
+		return nil, false
+	}
+	return d.apps[name], true
+}
+
+// Reporter returns a CreatorFunc that posts each incident with the reporter
+// of its tenant. Incidents for tenants without a reporter are rejected.
+func (c *Config) Reporter(reporters map[string]incident.CreatorFunc) incident.CreatorFunc {
+	return func(ctx context.Context, i models.Incident) (string, error) {
+		name, _ := c.Lookup(i.Tenant)
+
+		reporter, ok := reporters[name]
+		if !ok {
+			return "", &incident.RejectedError{Message: fmt.Sprintf("no incident api for tenant %s", i.Tenant)}
+		}
+
+		return reporter(ctx, i)
+	}
+}
@@ -0,0 +1,138 @@
+package tenants
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/categories"
+	"github.com/diwise/integration-incident/internal/pkg/application/seasons"
+	"gopkg.in/yaml.v3"
+)
+
+// Tenant holds what differs between the tenants of a shared deployment. The
+// categories are applied on top of the category mapping of the deployment and
+// the auth code may refer to environment variables as ${NAME}. Language selects
+// the locale catalog used for the incidents of the tenant and FreezeSeason,
+// when set, replaces the freeze season of the deployment.
+type Tenant struct {
+	GatewayURL   string             `yaml:"gatewayUrl,omitempty" json:"gatewayUrl,omitempty"`
+	AuthCode     string             `yaml:"authCode,omitempty" json:"-"`
+	Municipality string             `yaml:"municipality,omitempty" json:"municipality,omitempty"`
+	BrokerTenant string             `yaml:"brokerTenant,omitempty" json:"brokerTenant"`
+	Categories   categories.Mapping `yaml:"categories,omitempty" json:"categories,omitempty"`
+	Language     string             `yaml:"language,omitempty" json:"language,omitempty"`
+	FreezeSeason *seasons.Freeze    `yaml:"freezeSeason,omitempty" json:"freezeSeason,omitempty"`
+}
+
+// Config maps tenant names to their settings. Events for tenants that are not
+// configured are handled by the Default tenant, if there is one.
+type Config struct {
+	Default string            `yaml:"default,omitempty" json:"default,omitempty"`
+	Tenants map[string]Tenant `yaml:"tenants" json:"tenants"`
+}
+
+// Single returns a configuration where every event is handled by one tenant,
+// as when the service is deployed once per tenant
+func Single(name string, t Tenant) *Config {
+	if t.BrokerTenant == "" {
+		t.BrokerTenant = name
+	}
+	return &Config{Default: name, Tenants: map[string]Tenant{name: t}}
+}
+
+// Load reads a tenant configuration from a YAML (or JSON) file
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant configuration: %w", err)
+	}
+
+	return Parse(b)
+}
+
+func Parse(b []byte) (*Config, error) {
+	cfg := &Config{}
+
+	err := yaml.Unmarshal(b, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tenant configuration: %w", err)
+	}
+
+	for name, t := range cfg.Tenants {
+		t.AuthCode = os.ExpandEnv(t.AuthCode)
+		if t.BrokerTenant == "" {
+			t.BrokerTenant = name
+		}
+		cfg.Tenants[name] = t
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.Tenants) == 0 {
+		errs = append(errs, fmt.Errorf("no tenants configured"))
+	}
+
+	for _, name := range c.Names() {
+		t := c.Tenants[name]
+
+		if name == "" || strings.Contains(name, "/") {
+			errs = append(errs, fmt.Errorf("invalid tenant name \"%s\"", name))
+		}
+
+		if t.GatewayURL != "" {
+			u, err := url.Parse(t.GatewayURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("tenant %s: gateway url must be an absolute http(s) url", name))
+			}
+		}
+
+		if err := t.Categories.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+		}
+
+		if t.FreezeSeason != nil {
+			if err := t.FreezeSeason.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+			}
+		}
+	}
+
+	if c.Default != "" {
+		if _, ok := c.Tenants[c.Default]; !ok {
+			errs = append(errs, fmt.Errorf("default tenant %s is not configured", c.Default))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Names returns the names of the configured tenants in sorted order
+func (c *Config) Names() []string {
+	return slices.Sorted(maps.Keys(c.Tenants))
+}
+
+// Lookup returns the name of the tenant that handles events for the given
+// tenant. The tenant is matched against the tenant names first and then
+// against the broker tenants, before falling back to the default tenant.
+func (c *Config) Lookup(tenant string) (string, bool) {
+	if _, ok := c.Tenants[tenant]; ok && tenant != "" {
+		return tenant, true
+	}
+
+	for _, name := range c.Names() {
+		if tenant != "" && c.Tenants[name].BrokerTenant == tenant {
+			return name, true
+		}
+	}
+
+	return c.Default, c.Default != ""
+}
@@ -0,0 +1,145 @@
+package tenants
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-incident/internal/pkg/application"
+	"github.com/diwise/integration-incident/internal/pkg/application/services"
+	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/state"
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/matryer/is"
+)
+
+const tenantsYaml string = `
+default: sundsvall
+tenants:
+  sundsvall:
+    gatewayUrl: https://gateway.sundsvall.se
+    authCode: ${TEST_AUTH_CODE}
+    municipality: "2281"
+  timra:
+    gatewayUrl: https://gateway.timra.se
+    authCode: secret
+    municipality: "2262"
+    brokerTenant: timra-kommun
+    categories:
+      sewageoverflow: 42
+`
+
+func TestThatTenantsAreParsed(t *testing.T) {
+	is := is.New(t)
+	t.Setenv("TEST_AUTH_CODE", "Basic c3VuZHN2YWxs")
+
+	cfg, err := Parse([]byte(tenantsYaml))
+	is.NoErr(err)
+
+	is.Equal(cfg.Names(), []string{"sundsvall", "timra"})
+	is.Equal(cfg.Tenants["sundsvall"].AuthCode, "Basic c3VuZHN2YWxs")
+	is.Equal(cfg.Tenants["sundsvall"].BrokerTenant, "sundsvall")
+	is.Equal(cfg.Tenants["timra"].Categories["sewageoverflow"], 42)
+
+	for tenant, expected := range map[string]string{"timra": "timra", "timra-kommun": "timra", "": "sundsvall", "unknown": "sundsvall"} {
+		name, ok := cfg.Lookup(tenant)
+		is.True(ok)
+		is.Equal(name, expected)
+	}
+}
+
+func TestThatInvalidTenantsAreRejected(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte(`
+default: ange
+tenants:
+  a/b:
+    gatewayUrl: gateway.local
+    categories:
+      lifebuoy: 0
+`))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "invalid tenant name \"a/b\""))
+	is.True(strings.Contains(err.Error(), "absolute http(s) url"))
+	is.True(strings.Contains(err.Error(), "invalid category 0 for lifebuoy"))
+	is.True(strings.Contains(err.Error(), "default tenant ange is not configured"))
+}
+
+func TestThatEventsAreRoutedByTenantWithIsolatedState(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	cfg, err := Parse([]byte(tenantsYaml))
+	is.NoErr(err)
+
+	mx := sync.Mutex{}
+	posted := map[string][]models.Incident{}
+
+	reporters := map[string]incident.CreatorFunc{}
+	for _, name := range cfg.Names() {
+		reporters[name] = func(_ context.Context, i models.Incident) (string, error) {
+			mx.Lock()
+			defer mx.Unlock()
+			posted[name] = append(posted[name], i)
+			return "", nil
+		}
+	}
+
+	store := state.NewInMemoryStore()
+	apps := map[string]application.IntegrationIncident{}
+
+	for _, name := range cfg.Names() {
+		apps[name] = application.NewApplication(ctx, cfg.Reporter(reporters), &services.EntityLocatorMock{},
+			application.WithTenant(name),
+			application.WithStateStore(store),
+			application.WithCategories(cfg.Tenants[name].Categories),
+		)
+	}
+
+	d, err := NewDispatcher(cfg, apps)
+	is.NoErr(err)
+
+	overflow := func(tenant string) models.FunctionUpdated {
+		f := models.FunctionUpdated{Id: "pumpstation-1", Type: "stopwatch", SubType: "overflow", Tenant: tenant}
+		f.Stopwatch = &struct {
+			StartTime      time.Time      `json:"startTime"`
+			StopTime       *time.Time     `json:"stopTime,omitempty"`
+			Duration       *time.Duration `json:"duration,omitempty"`
+			State          bool           `json:"state"`
+			Count          int32          `json:"count"`
+			CumulativeTime time.Duration  `json:"cumulativeTime"`
+		}{State: true, Count: 1}
+		return f
+	}
+
+	// the same device reports for both tenants, which must not share dedup state
+	is.NoErr(d.SewageOverflowObserved(ctx, overflow("sundsvall")))
+	is.NoErr(d.SewageOverflowObserved(ctx, overflow("timra-kommun")))
+	is.NoErr(d.SewageOverflowObserved(ctx, overflow("timra")))
+
+	is.Equal(len(posted["sundsvall"]), 1)
+	is.Equal(len(posted["timra"]), 1)
+	is.Equal(posted["sundsvall"][0].Tenant, "sundsvall")
+	is.Equal(posted["timra"][0].Category, 42)
+	is.True(strings.HasPrefix(posted["timra"][0].Key, "timra/"))
+
+	_, exists, err := store.Get(ctx, posted["sundsvall"][0].Key)
+	is.NoErr(err)
+	is.True(exists)
+}
+
+func TestThatIncidentsForUnknownTenantsAreRejected(t *testing.T) {
+	is := is.New(t)
+
+	cfg := &Config{Tenants: map[string]Tenant{"sundsvall": {}}}
+
+	i := models.NewIncident(18, "Bräddning")
+	i.Tenant = "timra"
+
+	_, err := cfg.Reporter(map[string]incident.CreatorFunc{})(context.Background(), *i)
+	is.True(errors.Is(err, incident.ErrRejected))
+}
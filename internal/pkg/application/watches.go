@@ -0,0 +1,83 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/rules"
+	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/state"
+)
+
+// watchPrefix starts the keys that keep the last event of silent sources and running timers
+const watchPrefix = "watch:"
+
+type watched struct {
+	rule rules.Rule
+	evt  rules.Event
+	at   time.Time
+}
+
+func (a *app) watchKey(r rules.Rule, evt rules.Event) string {
+	return a.stateKey(watchPrefix + r.ID + ":" + r.Key(evt))
+}
+
+// watch stores the event as the last one seen for its rule and dedup key
+func (a *app) watch(ctx context.Context, r rules.Rule, evt rules.Event, at time.Time) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("could not store watched event: %s", err.Error())
+	}
+
+	err = a.state.Set(ctx, a.watchKey(r, evt), state.Entry{Updated: at, Rule: r.ID, Event: b})
+	if err != nil {
+		return fmt.Errorf("could not store state: %s", err.Error())
+	}
+
+	return nil
+}
+
+// forget stops watching a dedup key, such as that of a timer that has stopped,
+// by storing its entry without an event
+func (a *app) forget(ctx context.Context, r rules.Rule, evt rules.Event, at time.Time) error {
+	err := a.state.Set(ctx, a.watchKey(r, evt), state.Entry{Updated: at, Rule: r.ID})
+	if err != nil {
+		return fmt.Errorf("could not store state: %s", err.Error())
+	}
+
+	return nil
+}
+
+// due returns the sources that have been silent for too long and every running timer
+func (a *app) due(ctx context.Context, now time.Time) ([]watched, error) {
+	due := []watched{}
+	errs := []error{}
+
+	err := a.state.Range(ctx, a.stateKey(watchPrefix), func(key string, entry state.Entry) error {
+		if entry.Event == nil {
+			return nil
+		}
+
+		r, ok := a.rules.Find(entry.Rule)
+		if !ok || (r.Timer == nil && r.Silence == nil) {
+			return nil
+		}
+
+		if r.Timer == nil && now.Sub(entry.Updated) < r.Silence.After {
+			return nil
+		}
+
+		evt := rules.Event{}
+		if err := json.Unmarshal(entry.Event, &evt); err != nil {
+			errs = append(errs, fmt.Errorf("could not read watched event for %s: %s", key, err.Error()))
+			return nil
+		}
+
+		due = append(due, watched{rule: r, evt: evt, at: entry.Updated})
+		return nil
+	})
+
+	return due, errors.Join(append(errs, err)...)
+}
@@ -0,0 +1,114 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/rules"
+	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/state"
+)
+
+// windowPrefix starts the keys that keep the last value and recent increases of a window
+const windowPrefix = "window:"
+
+// window records the value and returns how much it has grown within the window.
+// A value below the previous one is taken as a reset counter.
+func (a *app) window(ctx context.Context, r rules.Rule, evt rules.Event, at time.Time, value float64) (float64, error) {
+	key := a.stateKey(windowPrefix + r.ID + ":" + r.Key(evt))
+	last := strconv.FormatFloat(value, 'f', -1, 64)
+
+	w, exists, err := a.state.Get(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("could not read state: %s", err.Error())
+	}
+
+	if !exists {
+		return 0, a.storeWindow(ctx, key, state.Entry{Value: last, Updated: at})
+	}
+
+	previous, err := strconv.ParseFloat(w.Value, 64)
+	if err != nil {
+		previous = value
+	}
+
+	amount := value - previous
+	if amount < 0 {
+		amount = value
+	}
+
+	if amount > 0 {
+		w.Increases = append(w.Increases, state.Increase{At: at, Amount: amount})
+	}
+
+	start := at.Add(-r.Window.Duration)
+	for len(w.Increases) > 0 && !w.Increases[0].At.After(start) {
+		w.Increases = w.Increases[1:]
+	}
+
+	total := 0.0
+	for _, i := range w.Increases {
+		total += i.Amount
+	}
+
+	w.Value, w.Updated = last, at
+
+	return total, a.storeWindow(ctx, key, w)
+}
+
+func (a *app) storeWindow(ctx context.Context, key string, w state.Entry) error {
+	err := a.state.Set(ctx, key, w)
+	if err != nil {
+		return fmt.Errorf("could not store state: %s", err.Error())
+	}
+	return nil
+}
+
+// baselinePrefix starts the keys that keep the values recorded for a baseline
+const baselinePrefix = "baseline:"
+
+// baseline returns the mean of the last n periods, or false if it is not known yet
+func (a *app) baseline(ctx context.Context, r rules.Rule, evt rules.Event, at time.Time, value float64) (float64, bool, error) {
+	key := a.stateKey(baselinePrefix + r.ID + ":" + r.Key(evt))
+	n := r.Baseline.Periods
+
+	p, exists, err := a.state.Get(ctx, key)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not read state: %s", err.Error())
+	}
+
+	if !exists {
+		return 0, false, a.storeBaseline(ctx, key, state.Entry{Updated: at})
+	}
+
+	mean, known := 0.0, len(p.Values) >= n
+	if known {
+		for _, v := range p.Values[len(p.Values)-n:] {
+			mean += v
+		}
+		mean /= float64(n)
+	}
+
+	if at.Sub(p.Updated) >= r.Baseline.Period {
+		p.Values = append(p.Values, value)
+		if len(p.Values) > n {
+			p.Values = p.Values[len(p.Values)-n:]
+		}
+		p.Updated = at
+
+		if err := a.storeBaseline(ctx, key, p); err != nil {
+			return 0, false, err
+		}
+	}
+
+	return mean, known, nil
+}
+
+func (a *app) storeBaseline(ctx context.Context, key string, p state.Entry) error {
+	err := a.state.Set(ctx, key, p)
+	if err != nil {
+		return fmt.Errorf("could not store state: %s", err.Error())
+	}
+	return nil
+}
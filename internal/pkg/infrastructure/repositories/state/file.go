@@ -0,0 +1,105 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var stateBucket = []byte("state")
+
+type fileStore struct {
+	db *bolt.DB
+}
+
+// NewFileStore opens, or creates, a bbolt database at path. Every write is
+// committed to disk before Set returns, so the state survives restarts.
+func NewFileStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(stateBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create state bucket: %w", err)
+	}
+
+	return &fileStore{db: db}, nil
+}
+
+func (s *fileStore) Get(_ context.Context, key string) (Entry, bool, error) {
+	var b []byte
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(stateBucket).Get([]byte(key)); v != nil {
+			b = append(b, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("failed to read state for %s: %w", key, err)
+	}
+
+	if b == nil {
+		return Entry{}, false, nil
+	}
+
+	e := Entry{}
+	err = json.Unmarshal(b, &e)
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("failed to unmarshal state for %s: %w", key, err)
+	}
+
+	return e, true, nil
+}
+
+func (s *fileStore) Set(_ context.Context, key string, entry Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state for %s: %w", key, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(stateBucket).Put([]byte(key), b)
+	})
+}
+
+func (s *fileStore) Range(_ context.Context, prefix string, fn func(key string, entry Entry) error) error {
+	items := map[string]Entry{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(stateBucket).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			e := Entry{}
+			if err := json.Unmarshal(v, &e); err != nil {
+				return fmt.Errorf("failed to unmarshal state for %s: %w", k, err)
+			}
+			items[string(k)] = e
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+
+	for k, e := range items {
+		if err := fn(k, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *fileStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,53 @@
+package state
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+type memoryStore struct {
+	mx    sync.Mutex
+	items map[string]Entry
+}
+
+func NewInMemoryStore() Store {
+	return &memoryStore{items: make(map[string]Entry)}
+}
+
+func (s *memoryStore) Get(_ context.Context, key string) (Entry, bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	e, ok := s.items[key]
+	return e, ok, nil
+}
+
+func (s *memoryStore) Set(_ context.Context, key string, entry Entry) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.items[key] = entry
+	return nil
+}
+
+func (s *memoryStore) Range(_ context.Context, prefix string, fn func(key string, entry Entry) error) error {
+	s.mx.Lock()
+	items := map[string]Entry{}
+	for k, e := range s.items {
+		if strings.HasPrefix(k, prefix) {
+			items[k] = e
+		}
+	}
+	s.mx.Unlock()
+
+	for k, e := range items {
+		if err := fn(k, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
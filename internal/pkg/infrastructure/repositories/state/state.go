@@ -0,0 +1,65 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Entry is the last known state for a dedup key. Reported is the time an
+// incident was last reported for the state, Reminders the number of reminders
+// that have been sent since the state changed and Incidents the ids of the
+// incidents that are still open. Rule is the id of the rule the state belongs
+// to and Event the event that the incident was last reported for, so that
+// reminders can be sent without waiting for another event. Values are the
+// values recorded at the end of each period of a baseline, Increases the recent
+// increases of a counter in a window, and Queued is set while the incident for
+// the state is waiting in a queue to be posted.
+type Entry struct {
+	Value     string          `json:"value"`
+	Updated   time.Time       `json:"updated"`
+	Reported  time.Time       `json:"reported,omitzero"`
+	Reminders int             `json:"reminders,omitempty"`
+	Incidents []string        `json:"incidents,omitempty"`
+	Rule      string          `json:"rule,omitempty"`
+	Event     json.RawMessage `json:"event,omitempty"`
+	Values    []float64       `json:"values,omitempty"`
+	Increases []Increase      `json:"increases,omitempty"`
+	Queued    bool            `json:"queued,omitempty"`
+}
+
+// Increase is how much a cumulative value grew at a point in time
+type Increase struct {
+	At     time.Time `json:"at"`
+	Amount float64   `json:"amount"`
+}
+
+// Store keeps the state used to decide whether an event has already been
+// reported, so that the same incident is not reported twice. Range calls fn
+// for every entry whose key starts with prefix, and stops at the first error.
+// The entries are read before fn is called, so fn may update the store.
+type Store interface {
+	Get(ctx context.Context, key string) (Entry, bool, error)
+	Set(ctx context.Context, key string, entry Entry) error
+	Range(ctx context.Context, prefix string, fn func(key string, entry Entry) error) error
+	Close() error
+}
+
+const (
+	TypeMemory string = "memory"
+	TypeFile   string = "file"
+)
+
+// New creates a store of the given type. The path is only used by file
+// backed stores.
+func New(storeType, path string) (Store, error) {
+	switch storeType {
+	case "", TypeMemory:
+		return NewInMemoryStore(), nil
+	case TypeFile:
+		return NewFileStore(path)
+	}
+
+	return nil, fmt.Errorf("unknown state store type \"%s\"", storeType)
+}
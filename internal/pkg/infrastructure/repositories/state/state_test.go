@@ -0,0 +1,92 @@
+package state
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestInMemoryStore(t *testing.T) {
+	is := is.New(t)
+
+	s, err := New(TypeMemory, "")
+	is.NoErr(err)
+
+	testGetAndSet(is, s)
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	s, err := New(TypeFile, path)
+	is.NoErr(err)
+
+	testGetAndSet(is, s)
+	is.NoErr(s.Close())
+
+	s, err = New(TypeFile, path)
+	is.NoErr(err)
+	defer s.Close()
+
+	e, ok, err := s.Get(ctx, "devId1:state")
+	is.NoErr(err)
+	is.True(ok)
+	is.Equal(e.Value, "2")
+}
+
+func TestRangeVisitsKeysWithPrefix(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	for _, storeType := range []string{TypeMemory, TypeFile} {
+		s, err := New(storeType, filepath.Join(t.TempDir(), "state.db"))
+		is.NoErr(err)
+
+		is.NoErr(s.Set(ctx, "a/devId1:state", Entry{Value: "1"}))
+		is.NoErr(s.Set(ctx, "a/devId2:state", Entry{Value: "2"}))
+		is.NoErr(s.Set(ctx, "b/devId1:state", Entry{Value: "3"}))
+
+		visited := map[string]string{}
+		err = s.Range(ctx, "a/", func(key string, e Entry) error {
+			visited[key] = e.Value
+			// the store may be updated while it is ranged over
+			return s.Set(ctx, key, Entry{Value: e.Value + "0"})
+		})
+		is.NoErr(err)
+		is.Equal(visited, map[string]string{"a/devId1:state": "1", "a/devId2:state": "2"})
+
+		e, _, err := s.Get(ctx, "a/devId1:state")
+		is.NoErr(err)
+		is.Equal(e.Value, "10")
+
+		is.NoErr(s.Close())
+	}
+}
+
+func TestUnknownStoreType(t *testing.T) {
+	is := is.New(t)
+
+	_, err := New("redis", "")
+	is.True(err != nil)
+}
+
+func testGetAndSet(is *is.I, s Store) {
+	ctx := context.Background()
+
+	_, ok, err := s.Get(ctx, "devId1:state")
+	is.NoErr(err)
+	is.True(!ok)
+
+	is.NoErr(s.Set(ctx, "devId1:state", Entry{Value: "1", Updated: time.Now()}))
+	is.NoErr(s.Set(ctx, "devId1:state", Entry{Value: "2", Updated: time.Now()}))
+
+	e, ok, err := s.Get(ctx, "devId1:state")
+	is.NoErr(err)
+	is.True(ok)
+	is.Equal(e.Value, "2")
+}
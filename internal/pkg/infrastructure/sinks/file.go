@@ -0,0 +1,70 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-incident/pkg/models"
+)
+
+// FileConfig configures a sink that appends incidents to a file, one JSON
+// object per line
+type FileConfig struct {
+	Path string `yaml:"path"`
+}
+
+type fileSink struct {
+	name string
+	mx   sync.Mutex
+	f    *os.File
+	now  func() time.Time
+}
+
+// line is what is written to the file for each incident
+type line struct {
+	Time     time.Time       `json:"time"`
+	Key      string          `json:"key,omitempty"`
+	Incident models.Incident `json:"incident"`
+}
+
+func NewFile(name string, cfg FileConfig) (IncidentSink, error) {
+	f, err := os.OpenFile(cfg.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open incident file: %w", err)
+	}
+
+	return &fileSink{
+		name: name,
+		f:    f,
+		now:  func() time.Time { return time.Now().UTC() },
+	}, nil
+}
+
+func (s *fileSink) Name() string {
+	return s.name
+}
+
+func (s *fileSink) Send(_ context.Context, i models.Incident) (string, error) {
+	b, err := json.Marshal(line{Time: s.now(), Key: i.Key, Incident: i})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal incident: %w", err)
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	_, err = s.f.Write(append(b, '\n'))
+	if err != nil {
+		return "", fmt.Errorf("failed to write incident to file: %w", err)
+	}
+
+	return "", nil
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
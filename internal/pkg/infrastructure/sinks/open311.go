@@ -0,0 +1,241 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Open311Config configures a sink that creates GeoReport v2 service requests.
+// Categories without a service code are used as service codes as they are.
+type Open311Config struct {
+	URL            string         `yaml:"url"`
+	APIKey         string         `yaml:"apiKey,omitempty"`
+	JurisdictionID string         `yaml:"jurisdictionId,omitempty"`
+	ServiceCodes   map[int]string `yaml:"serviceCodes,omitempty"`
+}
+
+func (c Open311Config) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("open311 requires an absolute http(s) url")
+	}
+
+	for category, code := range c.ServiceCodes {
+		if code == "" {
+			return fmt.Errorf("empty service code for category %d", category)
+		}
+	}
+
+	return nil
+}
+
+// Service is an entry in the service list of an Open311 endpoint
+type Service struct {
+	ServiceCode string `json:"service_code"`
+	ServiceName string `json:"service_name"`
+	Description string `json:"description"`
+	Metadata    bool   `json:"metadata"`
+	Type        string `json:"type"`
+	Group       string `json:"group"`
+}
+
+type serviceRequest struct {
+	ServiceRequestID string `json:"service_request_id"`
+	Token            string `json:"token"`
+	ServiceNotice    string `json:"service_notice"`
+}
+
+type open311Error struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+}
+
+// servicesMaxAge is how long the service list is used before it is fetched again
+const servicesMaxAge = time.Hour
+
+type open311 struct {
+	name       string
+	cfg        Open311Config
+	httpClient *http.Client
+
+	mx       sync.Mutex
+	services []Service
+	fetched  time.Time
+	now      func() time.Time
+}
+
+func NewOpen311(name string, cfg Open311Config) (IncidentSink, error) {
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	cfg.APIKey = os.ExpandEnv(cfg.APIKey)
+
+	return &open311{
+		name: name,
+		cfg:  cfg,
+		httpClient: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   10 * time.Second,
+		},
+		now: time.Now,
+	}, nil
+}
+
+func (s *open311) Name() string {
+	return s.name
+}
+
+// Send creates a service request and returns its id, or its token if there is no id yet
+func (s *open311) Send(ctx context.Context, i models.Incident) (string, error) {
+	code := s.serviceCode(i.Category)
+
+	services, err := s.Services(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if !slices.ContainsFunc(services, func(svc Service) bool { return svc.ServiceCode == code }) {
+		return "", &incident.RejectedError{Message: fmt.Sprintf("no open311 service with code %s for category %d", code, i.Category)}
+	}
+
+	form := url.Values{}
+	form.Set("service_code", code)
+	form.Set("description", i.Description)
+	s.authenticate(form)
+
+	if lat, long, ok := strings.Cut(i.MapCoordinates, ","); ok {
+		form.Set("lat", strings.TrimSpace(lat))
+		form.Set("long", strings.TrimSpace(long))
+	}
+
+	requests := []serviceRequest{}
+
+	err = s.do(ctx, http.MethodPost, s.cfg.URL+"/requests.json", form, &requests)
+	if err != nil {
+		return "", fmt.Errorf("failed to create service request: %w", err)
+	}
+
+	if len(requests) == 0 {
+		return "", fmt.Errorf("%w: no service request in response", incident.ErrMalformedResponse)
+	}
+
+	requestID := requests[0].ServiceRequestID
+	if requestID == "" {
+		requestID = requests[0].Token
+	}
+
+	logging.GetFromContext(ctx).Info("open311 service request created", "sink", s.name, "service_code", code, "service_request_id", requestID)
+
+	return requestID, nil
+}
+
+// Services returns the service list of the endpoint. The list is fetched when
+// it is first needed and then kept for an hour.
+func (s *open311) Services(ctx context.Context) ([]Service, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.services != nil && s.now().Sub(s.fetched) < servicesMaxAge {
+		return s.services, nil
+	}
+
+	params := url.Values{}
+	if s.cfg.JurisdictionID != "" {
+		params.Set("jurisdiction_id", s.cfg.JurisdictionID)
+	}
+
+	services := []Service{}
+
+	err := s.do(ctx, http.MethodGet, s.cfg.URL+"/services.json?"+params.Encode(), nil, &services)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch open311 services: %w", err)
+	}
+
+	s.services = services
+	s.fetched = s.now()
+
+	return services, nil
+}
+
+func (s *open311) serviceCode(category int) string {
+	if code, ok := s.cfg.ServiceCodes[category]; ok {
+		return code
+	}
+	return strconv.Itoa(category)
+}
+
+func (s *open311) authenticate(form url.Values) {
+	if s.cfg.APIKey != "" {
+		form.Set("api_key", s.cfg.APIKey)
+	}
+	if s.cfg.JurisdictionID != "" {
+		form.Set("jurisdiction_id", s.cfg.JurisdictionID)
+	}
+}
+
+// do sends a request and decodes the response. Error responses carry a list
+// of codes and descriptions, of which the first description is kept.
+func (s *open311) do(ctx context.Context, method, requestUrl string, form url.Values, result any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: request failed: %w", incident.ErrTransient, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read response body: %w", incident.ErrTransient, err)
+	}
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: response code %d", incident.ErrTransient, resp.StatusCode)
+	case resp.StatusCode >= http.StatusMultipleChoices:
+		message := string(b)
+		errs := []open311Error{}
+		if json.Unmarshal(b, &errs) == nil && len(errs) > 0 {
+			message = errs[0].Description
+		}
+		return &incident.RejectedError{StatusCode: resp.StatusCode, Message: message}
+	}
+
+	err = json.Unmarshal(b, result)
+	if err != nil {
+		return fmt.Errorf("%w: %w", incident.ErrMalformedResponse, err)
+	}
+
+	return nil
+}
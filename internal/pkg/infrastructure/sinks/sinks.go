@@ -0,0 +1,413 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/locale"
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// IncidentSink delivers incidents and returns the id they were given, if any
+type IncidentSink interface {
+	Name() string
+	Send(ctx context.Context, i models.Incident) (string, error)
+}
+
+const (
+	TypeIncidentAPI string = "incidentapi"
+	TypeWebhook     string = "webhook"
+	TypeFile        string = "file"
+	TypeSMTP        string = "smtp"
+	TypeOpen311     string = "open311"
+)
+
+// Config names the sinks and the routes that decide which of them receive an
+// incident
+type Config struct {
+	Sinks  []SinkConfig `yaml:"sinks"`
+	Routes []Route      `yaml:"routes"`
+}
+
+// SinkConfig configures a single sink, with its settings in the section named after its type
+type SinkConfig struct {
+	Name    string         `yaml:"name"`
+	Type    string         `yaml:"type"`
+	Webhook *WebhookConfig `yaml:"webhook,omitempty"`
+	File    *FileConfig    `yaml:"file,omitempty"`
+	SMTP    *SMTPConfig    `yaml:"smtp,omitempty"`
+	Open311 *Open311Config `yaml:"open311,omitempty"`
+}
+
+// Route sends incidents with one of the listed categories to the listed sinks.
+// A route without categories is used for incidents that no other route matches.
+type Route struct {
+	Categories []int    `yaml:"categories,omitempty"`
+	Sinks      []string `yaml:"sinks"`
+}
+
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sinks file: %w", err)
+	}
+
+	return Parse(b)
+}
+
+// Parse decodes the sink configuration from YAML or JSON
+func Parse(b []byte) (*Config, error) {
+	cfg := Config{}
+
+	err := yaml.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal sinks: %w", err)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (cfg *Config) Validate() error {
+	var errs []error
+	names := map[string]bool{}
+
+	for i, s := range cfg.Sinks {
+		if s.Name == "" {
+			errs = append(errs, fmt.Errorf("sink %d has no name", i))
+			continue
+		}
+
+		if names[s.Name] {
+			errs = append(errs, fmt.Errorf("duplicate sink name %s", s.Name))
+		}
+		names[s.Name] = true
+
+		if err := s.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", s.Name, err))
+		}
+	}
+
+	if len(cfg.Routes) == 0 {
+		errs = append(errs, fmt.Errorf("at least one route is required"))
+	}
+
+	for i, r := range cfg.Routes {
+		if len(r.Sinks) == 0 {
+			errs = append(errs, fmt.Errorf("route %d has no sinks", i))
+		}
+		for _, name := range r.Sinks {
+			if !names[name] {
+				errs = append(errs, fmt.Errorf("route %d refers to unknown sink %s", i, name))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Uses reports whether any sink is of the given type
+func (cfg *Config) Uses(sinkType string) bool {
+	return slices.ContainsFunc(cfg.Sinks, func(s SinkConfig) bool { return s.Type == sinkType })
+}
+
+func (s SinkConfig) validate() error {
+	switch s.Type {
+	case TypeIncidentAPI:
+		return nil
+	case TypeWebhook:
+		if s.Webhook == nil {
+			return fmt.Errorf("webhook settings are required")
+		}
+		return s.Webhook.validate()
+	case TypeFile:
+		if s.File == nil || s.File.Path == "" {
+			return fmt.Errorf("file path is required")
+		}
+		return nil
+	case TypeSMTP:
+		if s.SMTP == nil {
+			return fmt.Errorf("smtp settings are required")
+		}
+		return s.SMTP.validate()
+	case TypeOpen311:
+		if s.Open311 == nil {
+			return fmt.Errorf("open311 settings are required")
+		}
+		return s.Open311.validate()
+	}
+
+	return fmt.Errorf("unknown sink type \"%s\"", s.Type)
+}
+
+// Router is an IncidentSink that sends each incident to the sinks routed to by its category
+type Router struct {
+	mx        sync.Mutex
+	sinks     map[string]IncidentSink
+	types     map[string]string
+	routes    []Route
+	delivered map[string]*delivery
+	now       func() time.Time
+}
+
+// delivery records the sinks that have accepted an incident, and the id it was
+// given by the incident API, while it is waiting to be sent to the others
+type delivery struct {
+	sinks      []string
+	incidentID string
+	updated    time.Time
+}
+
+// deliveryTTL is how long a partial delivery is remembered. An incident that
+// is not sent again within that time is sent to all of its sinks next time.
+const deliveryTTL time.Duration = 24 * time.Hour
+
+// NewRouter creates the configured sinks, posting to the incident API with reporter
+func NewRouter(cfg *Config, reporter incident.CreatorFunc, catalogs locale.Catalogs) (*Router, error) {
+	r := &Router{
+		sinks:     map[string]IncidentSink{},
+		types:     map[string]string{},
+		routes:    cfg.Routes,
+		delivered: map[string]*delivery{},
+		now:       time.Now,
+	}
+
+	for _, s := range cfg.Sinks {
+		var sink IncidentSink
+		var err error
+
+		switch s.Type {
+		case TypeIncidentAPI:
+			sink = FromReporter(s.Name, reporter)
+		case TypeWebhook:
+			sink, err = NewWebhook(s.Name, *s.Webhook)
+		case TypeFile:
+			sink, err = NewFile(s.Name, *s.File)
+		case TypeSMTP:
+			sink, err = NewSMTP(s.Name, *s.SMTP, catalogs)
+		case TypeOpen311:
+			sink, err = NewOpen311(s.Name, *s.Open311)
+		}
+
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to create sink %s: %w", s.Name, err)
+		}
+
+		r.sinks[s.Name] = sink
+		r.types[s.Name] = s.Type
+	}
+
+	return r, nil
+}
+
+func (r *Router) Name() string {
+	return "router"
+}
+
+// Send delivers the incident to the sinks that have not accepted it yet, and
+// returns the id given by the incidentapi sink
+func (r *Router) Send(ctx context.Context, i models.Incident) (string, error) {
+	log := logging.GetFromContext(ctx)
+
+	names := r.route(i.Category)
+	if len(names) == 0 {
+		log.Warn("no sink for incident", "category", i.Category, "key", i.Key)
+		return "", nil
+	}
+
+	key := deliveryKey(i)
+	d := r.pending(key)
+
+	var failed []string
+	var transient, permanent []error
+
+	for _, name := range names {
+		if slices.Contains(d.sinks, name) {
+			continue
+		}
+
+		id, err := r.sinks[name].Send(ctx, i)
+		if err != nil {
+			err = fmt.Errorf("sink %s: %w", name, err)
+			if incident.IsPermanent(err) {
+				permanent = append(permanent, err)
+			} else {
+				failed = append(failed, name)
+				transient = append(transient, err)
+			}
+			continue
+		}
+
+		d.sinks = append(d.sinks, name)
+		if d.incidentID == "" && r.types[name] == TypeIncidentAPI {
+			d.incidentID = id
+		}
+	}
+
+	if len(d.sinks) == 0 && len(transient) == 0 {
+		return "", errors.Join(permanent...)
+	}
+
+	for _, err := range permanent {
+		log.Error("failed to deliver incident", "key", i.Key, "err", err.Error())
+	}
+
+	if len(transient) > 0 {
+		r.remember(key, d)
+		return "", fmt.Errorf("%w: failed to deliver incident to %s: %w", incident.ErrTransient, strings.Join(failed, ", "), errors.Join(transient...))
+	}
+
+	r.forget(key)
+
+	return d.incidentID, nil
+}
+
+// pending returns a copy of the delivery of the incident with the given key,
+// or an empty delivery if it has not been sent to any sink yet
+func (r *Router) pending(key string) *delivery {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	d, ok := r.delivered[key]
+	if !ok || r.now().Sub(d.updated) > deliveryTTL {
+		return &delivery{}
+	}
+
+	return &delivery{sinks: slices.Clone(d.sinks), incidentID: d.incidentID}
+}
+
+func (r *Router) remember(key string, d *delivery) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	now := r.now()
+
+	for k, old := range r.delivered {
+		if now.Sub(old.updated) > deliveryTTL {
+			delete(r.delivered, k)
+		}
+	}
+
+	if len(d.sinks) > 0 {
+		d.updated = now
+		r.delivered[key] = d
+	}
+}
+
+func (r *Router) forget(key string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	delete(r.delivered, key)
+}
+
+// deliveryKey identifies an incident across attempts to send it. The dedup key
+// alone is not enough, since a rule may report several incidents over time.
+func deliveryKey(i models.Incident) string {
+	return fmt.Sprintf("%s/%s/%d/%s/%s", i.Tenant, i.Key, i.Category, i.MapCoordinates, i.Description)
+}
+
+// Reporter adapts the router to a CreatorFunc
+func (r *Router) Reporter() incident.CreatorFunc {
+	return r.Send
+}
+
+// Close closes the sinks that hold on to resources, such as open files
+func (r *Router) Close() error {
+	var errs []error
+
+	for _, s := range r.sinks {
+		if c, ok := s.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// route returns the names of the sinks for a category, in the order they are
+// first mentioned by the routes
+func (r *Router) route(category int) []string {
+	names := []string{}
+	var fallback []string
+
+	for _, route := range r.routes {
+		if len(route.Categories) == 0 {
+			fallback = append(fallback, route.Sinks...)
+			continue
+		}
+		if slices.Contains(route.Categories, category) {
+			names = append(names, route.Sinks...)
+		}
+	}
+
+	if len(names) == 0 {
+		names = fallback
+	}
+
+	unique := []string{}
+	for _, name := range names {
+		if !slices.Contains(unique, name) {
+			unique = append(unique, name)
+		}
+	}
+
+	return unique
+}
+
+type reporterSink struct {
+	name     string
+	reporter incident.CreatorFunc
+}
+
+// FromReporter adapts a CreatorFunc, such as the one of the incident API
+// client, to an IncidentSink
+func FromReporter(name string, reporter incident.CreatorFunc) IncidentSink {
+	return &reporterSink{name: name, reporter: reporter}
+}
+
+func (s *reporterSink) Name() string {
+	return s.name
+}
+
+func (s *reporterSink) Send(ctx context.Context, i models.Incident) (string, error) {
+	return s.reporter(ctx, i)
+}
+
+// templateFuncs are available to the templates of webhooks and emails
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func render(t *template.Template, data any) (string, error) {
+	var b strings.Builder
+	err := t.Execute(&b, data)
+	return b.String(), err
+}
+
+func parseTemplate(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
@@ -0,0 +1,492 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/locale"
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/matryer/is"
+)
+
+func TestThatIncidentsAreRoutedByCategory(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "incidents.jsonl")
+
+	cfg, err := Parse(fmt.Appendf(nil, `
+sinks:
+  - name: gateway
+    type: incidentapi
+  - name: log
+    type: file
+    file:
+      path: %s
+routes:
+  - categories: [18]
+    sinks: [gateway, log]
+  - sinks: [log]
+`, path))
+	is.NoErr(err)
+
+	posted := []int{}
+	r, err := NewRouter(cfg, func(ctx context.Context, i models.Incident) (string, error) {
+		posted = append(posted, i.Category)
+		return "SP_1", nil
+	}, locale.Default())
+	is.NoErr(err)
+
+	incidentID, err := r.Send(ctx, *models.NewIncident(18, "Bräddning"))
+	is.NoErr(err)
+	is.Equal(incidentID, "SP_1")
+
+	incidentID, err = r.Send(ctx, *models.NewIncident(15, "Livboj"))
+	is.NoErr(err)
+	is.Equal(incidentID, "")
+
+	is.NoErr(r.Close())
+
+	is.Equal(posted, []int{18})
+
+	b, err := os.ReadFile(path)
+	is.NoErr(err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	is.Equal(len(lines), 2)
+	is.True(strings.Contains(lines[1], `"description":"Livboj"`))
+}
+
+func TestThatOnlyFailedSinksAreRetried(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	gatewayUp := false
+	posted := map[string]int{}
+
+	sink := func(name, id string, up *bool) IncidentSink {
+		return FromReporter(name, func(context.Context, models.Incident) (string, error) {
+			if up != nil && !*up {
+				return "", fmt.Errorf("%w: response code 503", incident.ErrTransient)
+			}
+			posted[name]++
+			return id, nil
+		})
+	}
+
+	r := &Router{
+		sinks: map[string]IncidentSink{
+			"gateway": sink("gateway", "SP_1", &gatewayUp),
+			"backup":  sink("backup", "B_1", nil),
+		},
+		types:     map[string]string{"gateway": TypeIncidentAPI, "backup": TypeOpen311},
+		routes:    []Route{{Sinks: []string{"gateway", "backup"}}},
+		delivered: map[string]*delivery{},
+		now:       time.Now,
+	}
+
+	i := *models.NewIncident(18, "Bräddning")
+	i.Key = "devId1:state"
+
+	_, err := r.Send(ctx, i)
+	is.True(errors.Is(err, incident.ErrTransient))
+	is.True(!incident.IsPermanent(err))
+	is.True(strings.Contains(err.Error(), "gateway"))
+
+	gatewayUp = true
+
+	incidentID, err := r.Send(ctx, i)
+	is.NoErr(err)
+	is.Equal(incidentID, "SP_1")
+	is.Equal(posted, map[string]int{"gateway": 1, "backup": 1})
+	is.Equal(len(r.delivered), 0)
+}
+
+func TestThatRejectingSinksAreNotRetried(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	rejecting := func(context.Context, models.Incident) (string, error) {
+		return "", &incident.RejectedError{StatusCode: http.StatusBadRequest}
+	}
+
+	r := &Router{
+		sinks: map[string]IncidentSink{
+			"gateway": FromReporter("gateway", rejecting),
+			"backup":  FromReporter("backup", func(context.Context, models.Incident) (string, error) { return "B_1", nil }),
+		},
+		types:     map[string]string{"gateway": TypeIncidentAPI, "backup": TypeOpen311},
+		routes:    []Route{{Sinks: []string{"gateway", "backup"}}},
+		delivered: map[string]*delivery{},
+		now:       time.Now,
+	}
+
+	incidentID, err := r.Send(ctx, *models.NewIncident(18, "Bräddning"))
+	is.NoErr(err)
+	is.Equal(incidentID, "")
+
+	r.sinks["backup"] = FromReporter("backup", rejecting)
+
+	_, err = r.Send(ctx, *models.NewIncident(18, "Bräddning"))
+	is.True(incident.IsPermanent(err))
+}
+
+func TestThatInvalidSinksAreRejected(t *testing.T) {
+	is := is.New(t)
+
+	_, err := Parse([]byte(`
+sinks:
+  - name: hook
+    type: webhook
+    webhook:
+      url: ftp://example.com
+  - name: hook
+    type: pager
+routes:
+  - sinks: [hook, unknown]
+`))
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "absolute http(s) url"))
+	is.True(strings.Contains(err.Error(), "duplicate sink name hook"))
+	is.True(strings.Contains(err.Error(), "unknown sink unknown"))
+}
+
+func TestWebhookRendersBody(t *testing.T) {
+	is := is.New(t)
+
+	var body, token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, token = string(b), r.Header.Get("X-Token")
+		if strings.Contains(body, "trasig") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	hook, err := NewWebhook("hook", WebhookConfig{
+		URL:     server.URL,
+		Headers: map[string]string{"X-Token": "secret"},
+		Body:    `{"text": {{json .Description}}, "position": "{{.MapCoordinates}}"}`,
+	})
+	is.NoErr(err)
+
+	_, err = hook.Send(context.Background(), *models.NewIncident(18, "Bräddning").AtLocation(62.39, 17.3))
+	is.NoErr(err)
+	is.Equal(body, `{"text": "Bräddning", "position": "62.390000,17.300000"}`)
+	is.Equal(token, "secret")
+
+	_, err = hook.Send(context.Background(), *models.NewIncident(18, "trasig"))
+	is.True(errors.Is(err, incident.ErrRejected))
+}
+
+func TestSMTPSendsEmail(t *testing.T) {
+	is := is.New(t)
+
+	server := newFakeSMTPServer(t)
+
+	host, port, _ := net.SplitHostPort(server.addr)
+	p, _ := strconv.Atoi(port)
+
+	sink, err := NewSMTP("oncall", SMTPConfig{
+		Host: host,
+		Port: p,
+		From: "incident@diwise.io",
+		To:   []string{"jour@example.com"},
+	}, locale.Default())
+	is.NoErr(err)
+
+	_, err = sink.Send(context.Background(), *models.NewIncident(18, "Bräddning vid Pumpstation 1"))
+	is.NoErr(err)
+
+	msg := <-server.messages
+	is.Equal(msg.from, "<incident@diwise.io>")
+	is.Equal(msg.to, []string{"<jour@example.com>"})
+	is.True(strings.Contains(msg.data, "Subject: =?utf-8?q?Nytt_=C3=A4rende:_Br=C3=A4ddning_vid_Pumpstation_1?="))
+	is.True(strings.Contains(msg.data, "Beskrivning: Bräddning vid Pumpstation 1\r\n"))
+}
+
+func TestSMTPWritesInTheLanguageOfTheSink(t *testing.T) {
+	is := is.New(t)
+
+	server := newFakeSMTPServer(t)
+
+	host, port, _ := net.SplitHostPort(server.addr)
+	p, _ := strconv.Atoi(port)
+
+	sink, err := NewSMTP("oncall", SMTPConfig{
+		Host:     host,
+		Port:     p,
+		From:     "incident@diwise.io",
+		To:       []string{"oncall@example.com"},
+		Language: "en",
+	}, locale.Default())
+	is.NoErr(err)
+
+	_, err = sink.Send(context.Background(), *models.NewIncident(18, "Overflow at pumping station 1"))
+	is.NoErr(err)
+
+	msg := <-server.messages
+	is.True(strings.Contains(msg.data, "Subject: New incident: Overflow at pumping station 1\r\n"))
+	is.True(strings.Contains(msg.data, "Description: Overflow at pumping station 1\r\n"))
+
+	_, err = NewSMTP("oncall", SMTPConfig{Host: host, From: "incident@diwise.io", To: []string{"oncall@example.com"}, Language: "fi"}, locale.Default())
+	is.True(err != nil)
+}
+
+func TestSMTPGivesUpOnServerThatDoesNotAnswer(t *testing.T) {
+	is := is.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	t.Cleanup(func() { ln.Close() })
+
+	// accept connections, but never greet the client
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	sink, err := NewSMTP("oncall", SMTPConfig{Host: host, Port: p, From: "incident@diwise.io", To: []string{"jour@example.com"}}, locale.Default())
+	is.NoErr(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = sink.Send(ctx, *models.NewIncident(18, "Bräddning vid Pumpstation 1"))
+	is.True(errors.Is(err, incident.ErrTransient))
+	is.True(time.Since(start) < 5*time.Second)
+}
+
+func TestSMTPErrorsAreClassified(t *testing.T) {
+	is := is.New(t)
+
+	for reply, permanent := range map[string]bool{"550 No such user": true, "451 Try again later": false} {
+		server := newFakeSMTPServer(t)
+		server.rcptReply = reply
+
+		host, port, _ := net.SplitHostPort(server.addr)
+		p, _ := strconv.Atoi(port)
+
+		sink, err := NewSMTP("oncall", SMTPConfig{Host: host, Port: p, From: "incident@diwise.io", To: []string{"jour@example.com"}}, locale.Default())
+		is.NoErr(err)
+
+		_, err = sink.Send(context.Background(), *models.NewIncident(18, "Bräddning vid Pumpstation 1"))
+		is.Equal(incident.IsPermanent(err), permanent)
+		is.Equal(errors.Is(err, incident.ErrTransient), !permanent)
+	}
+
+	sink, err := NewSMTP("oncall", SMTPConfig{Host: "localhost", From: "incident@diwise.io", To: []string{"jour@example.com"}, Subject: "{{ .NoSuchField }}"}, locale.Default())
+	is.NoErr(err)
+
+	_, err = sink.Send(context.Background(), *models.NewIncident(18, "Bräddning vid Pumpstation 1"))
+	is.True(errors.Is(err, incident.ErrRejected))
+}
+
+type fakeMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+type fakeSMTPServer struct {
+	addr      string
+	messages  chan fakeMessage
+	rcptReply string
+}
+
+// newFakeSMTPServer answers just enough of SMTP for net/smtp to deliver a
+// message, which is passed on to the messages channel
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTPServer{addr: ln.Addr().String(), messages: make(chan fakeMessage, 10)}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := textproto.NewReader(bufio.NewReader(conn))
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	msg := fakeMessage{}
+	reply("220 localhost ESMTP")
+
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch {
+		case cmd == "EHLO" || cmd == "HELO":
+			reply("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			msg.from = line[len("MAIL FROM:"):]
+			reply("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			if s.rcptReply != "" {
+				reply(s.rcptReply)
+				continue
+			}
+			msg.to = append(msg.to, line[len("RCPT TO:"):])
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			b, err := r.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = strings.ReplaceAll(string(b), "\n", "\r\n")
+			s.messages <- msg
+			msg = fakeMessage{}
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestOpen311CreatesServiceRequest(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	server := newFakeOpen311Server(t)
+
+	sink, err := NewOpen311("city", Open311Config{
+		URL:            server.URL + "/",
+		APIKey:         "secret",
+		JurisdictionID: "sundsvall.se",
+		ServiceCodes:   map[int]string{18: "sewer-overflow"},
+	})
+	is.NoErr(err)
+
+	requestID, err := sink.Send(ctx, *models.NewIncident(18, "Bräddning").AtLocation(62.39, 17.3))
+	is.NoErr(err)
+	is.Equal(requestID, "SR_1")
+
+	req := server.requests[0]
+	is.Equal(req.Get("service_code"), "sewer-overflow")
+	is.Equal(req.Get("description"), "Bräddning")
+	is.Equal(req.Get("lat"), "62.390000")
+	is.Equal(req.Get("long"), "17.300000")
+	is.Equal(req.Get("api_key"), "secret")
+	is.Equal(req.Get("jurisdiction_id"), "sundsvall.se")
+
+	// unmapped categories are used as service codes as they are
+	requestID, err = sink.Send(ctx, *models.NewIncident(15, "Livboj"))
+	is.NoErr(err)
+	is.Equal(requestID, "token-2")
+
+	_, err = sink.Send(ctx, *models.NewIncident(17, "Nivå"))
+	is.True(errors.Is(err, incident.ErrRejected))
+
+	server.reject = true
+	_, err = sink.Send(ctx, *models.NewIncident(18, "Bräddning"))
+	is.True(errors.Is(err, incident.ErrRejected))
+	is.True(strings.Contains(err.Error(), "Invalid api_key"))
+
+	// the service list is only fetched once
+	is.Equal(server.discoveries, 1)
+}
+
+type fakeOpen311Server struct {
+	*httptest.Server
+	discoveries int
+	requests    []url.Values
+	reject      bool
+}
+
+// newFakeOpen311Server lists two services and creates service requests for
+// them. Requests for service 15 are answered with a token instead of an id.
+func newFakeOpen311Server(t *testing.T) *fakeOpen311Server {
+	s := &fakeOpen311Server{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /services.json", func(w http.ResponseWriter, r *http.Request) {
+		s.discoveries++
+		if r.URL.Query().Get("jurisdiction_id") != "sundsvall.se" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"service_code":"sewer-overflow","service_name":"Bräddning","metadata":false,"type":"realtime"},
+			{"service_code":"15","service_name":"Livboj","metadata":false,"type":"batch"}
+		]`))
+	})
+	mux.HandleFunc("POST /requests.json", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+
+		if s.reject {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`[{"code":403,"description":"Invalid api_key"}]`))
+			return
+		}
+
+		s.requests = append(s.requests, r.PostForm)
+
+		if r.PostForm.Get("service_code") == "15" {
+			fmt.Fprintf(w, `[{"token":"token-%d"}]`, len(s.requests))
+			return
+		}
+		fmt.Fprintf(w, `[{"service_request_id":"SR_%d","service_notice":""}]`, len(s.requests))
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
@@ -0,0 +1,241 @@
+package sinks
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/locale"
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// SMTPConfig configures a sink that emails incidents, authenticating only if Username is set
+type SMTPConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port,omitempty"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	Subject  string   `yaml:"subject,omitempty"`
+	Body     string   `yaml:"body,omitempty"`
+	Language string   `yaml:"language,omitempty"`
+}
+
+func (c SMTPConfig) validate() error {
+	if c.Host == "" || c.From == "" || len(c.To) == 0 {
+		return fmt.Errorf("smtp requires host, from and at least one recipient")
+	}
+
+	if _, err := parseTemplate("subject", c.Subject, ""); err != nil {
+		return fmt.Errorf("invalid subject: %w", err)
+	}
+
+	if _, err := parseTemplate("body", c.Body, ""); err != nil {
+		return fmt.Errorf("invalid body: %w", err)
+	}
+
+	return nil
+}
+
+type smtpSink struct {
+	name    string
+	cfg     SMTPConfig
+	subject *template.Template
+	body    *template.Template
+	now     func() time.Time
+}
+
+func NewSMTP(name string, cfg SMTPConfig, catalogs locale.Catalogs) (IncidentSink, error) {
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	catalog, err := catalogs.Get(cfg.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Port == 0 {
+		cfg.Port = 25
+	}
+
+	cfg.Password = os.ExpandEnv(cfg.Password)
+
+	subject, err := parseTemplate("subject", cfg.Subject, catalog.Text("smtp.subject"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject in catalog %s: %w", catalog.Language, err)
+	}
+
+	body, err := parseTemplate("body", cfg.Body, catalog.Text("smtp.body"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid body in catalog %s: %w", catalog.Language, err)
+	}
+
+	return &smtpSink{name: name, cfg: cfg, subject: subject, body: body, now: time.Now}, nil
+}
+
+func (s *smtpSink) Name() string {
+	return s.name
+}
+
+func (s *smtpSink) Send(ctx context.Context, i models.Incident) (string, error) {
+	subject, err := render(s.subject, i)
+	if err != nil {
+		return "", &incident.RejectedError{Message: fmt.Sprintf("failed to render subject: %s", err.Error())}
+	}
+
+	body, err := render(s.body, i)
+	if err != nil {
+		return "", &incident.RejectedError{Message: fmt.Sprintf("failed to render body: %s", err.Error())}
+	}
+
+	err = s.send(ctx, s.message(subject, body))
+	if err != nil {
+		return "", classifySMTPError(err)
+	}
+
+	logging.GetFromContext(ctx).Debug("incident emailed", "sink", s.name, "key", i.Key, "to", s.cfg.To)
+
+	return "", nil
+}
+
+// smtpTimeout bounds the whole conversation with the mail server, unless the
+// context has an earlier deadline
+const smtpTimeout = 10 * time.Second
+
+// send delivers the message like smtp.SendMail, over a connection that is closed at the deadline
+func (s *smtpSink) send(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(smtpTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: s.cfg.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return &incident.RejectedError{Message: "server does not support authentication"}
+		}
+
+		err = c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(s.cfg.From)
+	if err != nil {
+		return err
+	}
+
+	for _, to := range s.cfg.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// classifySMTPError takes a 5xx reply from the mail server as a rejection, and
+// anything else, such as a 4xx reply, a network error or a timeout, as transient
+func classifySMTPError(err error) error {
+	rejected := &incident.RejectedError{}
+	if errors.As(err, &rejected) {
+		return err
+	}
+
+	tpErr := &textproto.Error{}
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+		return &incident.RejectedError{StatusCode: tpErr.Code, Message: tpErr.Msg}
+	}
+
+	return fmt.Errorf("%w: failed to send email: %w", incident.ErrTransient, err)
+}
+
+func (s *smtpSink) message(subject, body string) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mimeHeader(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", s.now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	return []byte(b.String())
+}
+
+// mimeHeader encodes a header value that is not plain ASCII, such as a subject
+// in Swedish, and drops any line breaks
+func mimeHeader(s string) string {
+	s = strings.NewReplacer("\r", "", "\n", " ").Replace(s)
+	for _, r := range s {
+		if r > 127 {
+			return mime.QEncoding.Encode("utf-8", s)
+		}
+	}
+	return s
+}
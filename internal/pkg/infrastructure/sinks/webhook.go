@@ -0,0 +1,122 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/diwise/integration-incident/pkg/incident"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// WebhookConfig configures a sink that sends incidents to a URL, by default as JSON
+type WebhookConfig struct {
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	ContentType string            `yaml:"contentType,omitempty"`
+	Body        string            `yaml:"body,omitempty"`
+}
+
+func (c WebhookConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook requires an absolute http(s) url")
+	}
+
+	_, err = parseTemplate("body", c.Body, defaultWebhookBody)
+	if err != nil {
+		return fmt.Errorf("invalid webhook body: %w", err)
+	}
+
+	return nil
+}
+
+const defaultWebhookBody string = "{{json .}}"
+
+type webhook struct {
+	name       string
+	cfg        WebhookConfig
+	body       *template.Template
+	httpClient *http.Client
+}
+
+func NewWebhook(name string, cfg WebhookConfig) (IncidentSink, error) {
+	err := cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/json"
+	}
+
+	body, err := parseTemplate("body", cfg.Body, defaultWebhookBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook{
+		name: name,
+		cfg:  cfg,
+		body: body,
+		httpClient: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   10 * time.Second,
+		},
+	}, nil
+}
+
+func (w *webhook) Name() string {
+	return w.name
+}
+
+// Send renders the body and sends it to the webhook. As with the incident API,
+// 5xx responses are transient and other responses outside of 2xx rejections.
+func (w *webhook) Send(ctx context.Context, i models.Incident) (string, error) {
+	body, err := render(w.body, i)
+	if err != nil {
+		return "", &incident.RejectedError{Message: fmt.Sprintf("failed to render webhook body: %s", err.Error())}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(w.cfg.Method), w.cfg.URL, bytes.NewBufferString(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", w.cfg.ContentType)
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: webhook request failed: %w", incident.ErrTransient, err)
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests:
+		return "", fmt.Errorf("%w: webhook responded with %d", incident.ErrTransient, resp.StatusCode)
+	case resp.StatusCode >= http.StatusMultipleChoices:
+		return "", &incident.RejectedError{StatusCode: resp.StatusCode, Message: string(response)}
+	}
+
+	logging.GetFromContext(ctx).Debug("incident sent to webhook", "sink", w.name, "key", i.Key)
+
+	return "", nil
+}
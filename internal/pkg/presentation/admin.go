@@ -0,0 +1,37 @@
+package presentation
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// WithAdminToken sets the bearer token that is required by every route under
+// /admin. Without a token those routes respond with 403 Forbidden.
+func WithAdminToken(token string) Option {
+	return func(c *config) {
+		c.adminToken = token
+	}
+}
+
+func requireAdminToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				logging.GetFromContext(r.Context()).Warn("admin route called without an admin token configured", "path", r.URL.Path)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
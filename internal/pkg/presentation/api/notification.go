@@ -14,5 +14,9 @@ type Notification struct {
 		DeviceState *struct {
 			Value string `json:"value"`
 		} `json:"deviceState,omitempty"`
+		Temperature *struct {
+			Value      float64 `json:"value"`
+			ObservedAt string  `json:"observedAt,omitempty"`
+		} `json:"temperature,omitempty"`
 	} `json:"data"`
 }
@@ -0,0 +1,81 @@
+package presentation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/descriptions"
+	"github.com/go-chi/chi/v5"
+)
+
+// WithDescriptions exposes the description templates on /admin/descriptions
+// and lets templates be tried out on /admin/descriptions/preview
+func WithDescriptions(t *descriptions.Templates) Option {
+	return func(c *config) {
+		c.adminRoutes = append(c.adminRoutes, func(r chi.Router) {
+			r.Get("/descriptions", descriptionsHandler(t))
+			r.Post("/descriptions/preview", previewHandler(t))
+		})
+	}
+}
+
+func descriptionsHandler(t *descriptions.Templates) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sources := map[string]string{}
+		for _, kind := range t.Kinds() {
+			sources[kind], _ = t.Source(kind)
+		}
+
+		b, err := json.Marshal(sources)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	})
+}
+
+// previewRequest names the kind to preview and, optionally, a template to use
+// instead of the configured one and the data to render it with. The sample
+// data is used when no data is given.
+type previewRequest struct {
+	Kind     string             `json:"kind"`
+	Template string             `json:"template,omitempty"`
+	Data     *descriptions.Data `json:"data,omitempty"`
+}
+
+func previewHandler(t *descriptions.Templates) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := previewRequest{}
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req.Kind == "" {
+			http.Error(w, "request must name a kind", http.StatusBadRequest)
+			return
+		}
+
+		description, err := t.Preview(req.Kind, req.Template, req.Data)
+		if errors.Is(err, descriptions.ErrNoTemplate) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		b, err := json.Marshal(map[string]string{"description": description})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	})
+}
@@ -0,0 +1,62 @@
+package presentation
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/dryrun"
+	"github.com/go-chi/chi/v5"
+)
+
+// WithRecorder exposes the incidents recorded in dry run mode on
+// /admin/dryrun. The records can be filtered with the query parameters
+// action, category, since (RFC 3339) and limit.
+func WithRecorder(rec *dryrun.Recorder) Option {
+	return func(c *config) {
+		c.adminRoutes = append(c.adminRoutes, func(r chi.Router) {
+			r.Get("/dryrun", recorderHandler(rec))
+		})
+	}
+}
+
+func recorderHandler(rec *dryrun.Recorder) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+
+		q := r.URL.Query()
+		f := dryrun.Filter{Action: q.Get("action")}
+
+		if s := q.Get("category"); s != "" {
+			if f.Category, err = strconv.Atoi(s); err != nil {
+				http.Error(w, "invalid category", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if s := q.Get("since"); s != "" {
+			if f.Since, err = time.Parse(time.RFC3339, s); err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if s := q.Get("limit"); s != "" {
+			if f.Limit, err = strconv.Atoi(s); err != nil || f.Limit < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		b, err := json.Marshal(rec.Query(f))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	})
+}
@@ -8,12 +8,14 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/cloudevents/sdk-go/v2/client"
 	"github.com/diwise/integration-incident/internal/pkg/application"
-	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/models"
+	"github.com/diwise/integration-incident/internal/pkg/application/categories"
 	"github.com/diwise/integration-incident/internal/pkg/presentation/api"
+	"github.com/diwise/integration-incident/pkg/models"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
 	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
@@ -25,7 +27,30 @@ import (
 
 var tracer = otel.Tracer("integration-incident/handlers")
 
-func CreateRouter(ctx context.Context, app application.IntegrationIncident) (*chi.Mux, error) {
+type config struct {
+	adminRoutes  []func(chi.Router)
+	adminToken   string
+	healthChecks []healthCheck
+	statuses     []healthCheck
+}
+
+type Option func(*config)
+
+// WithCategories exposes the active category mapping on /admin/categories
+func WithCategories(m categories.Mapping) Option {
+	return func(c *config) {
+		c.adminRoutes = append(c.adminRoutes, func(r chi.Router) {
+			r.Get("/categories", categoriesHandler(m))
+		})
+	}
+}
+
+func CreateRouter(ctx context.Context, app application.IntegrationIncident, opts ...Option) (*chi.Mux, error) {
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(cors.New(cors.Options{
@@ -36,9 +61,8 @@ func CreateRouter(ctx context.Context, app application.IntegrationIncident) (*ch
 
 	r.Use(otelchi.Middleware("integration-incident", otelchi.WithChiRoutes(r)))
 
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
+	r.Get("/health", livenessHandler(cfg.statuses))
+	r.Get("/health/ready", readinessHandler(cfg.healthChecks))
 	r.Post("/api/notify", notificationHandler(ctx, app))
 
 	p, err := cloudevents.NewHTTP()
@@ -53,9 +77,30 @@ func CreateRouter(ctx context.Context, app application.IntegrationIncident) (*ch
 
 	r.Post("/api/cloudevents", cloudeventReceiveHandler(h))
 
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(requireAdminToken(cfg.adminToken))
+		for _, route := range cfg.adminRoutes {
+			route(r)
+		}
+	})
+
 	return r, nil
 }
 
+func categoriesHandler(m categories.Mapping) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.Marshal(m)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	})
+}
+
 func cloudeventReceiveHandler(h *client.EventReceiver) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		h.ServeHTTP(w, r)
@@ -87,13 +132,11 @@ func receive(ctx context.Context, app application.IntegrationIncident) func(cont
 				return
 			}
 
-			if strings.Contains(statusMessage.DeviceID, "se:servanet:lora:msva:") {
-				ctx = logging.NewContextWithLogger(ctx, log, "device_id", statusMessage.DeviceID)
-				err = app.DeviceStateUpdated(ctx, statusMessage.DeviceID, statusMessage)
-				if err != nil {
-					logging.GetFromContext(ctx).Error("device status updated failed", "err", err.Error())
-					return
-				}
+			ctx = logging.NewContextWithLogger(ctx, log, "device_id", statusMessage.DeviceID)
+			err = app.DeviceStateUpdated(ctx, statusMessage.DeviceID, statusMessage)
+			if err != nil {
+				logging.GetFromContext(ctx).Error("device status updated failed", "err", err.Error())
+				return
 			}
 		case "function.updated":
 			functionUpdated := models.FunctionUpdated{}
@@ -108,9 +151,15 @@ func receive(ctx context.Context, app application.IntegrationIncident) func(cont
 
 			if functionUpdated.Type == "stopwatch" && functionUpdated.SubType == "overflow" {
 				err = app.SewageOverflowObserved(ctx, functionUpdated)
+				if err != nil {
+					log.Error("sewer overflow failed", "err", err.Error())
+				}
+				return
 			}
+
+			err = app.FunctionUpdated(ctx, functionUpdated)
 			if err != nil {
-				log.Error("sewer overflow failed", "err", err.Error())
+				log.Error("function updated failed", "err", err.Error())
 				return
 			}
 		default:
@@ -155,18 +204,32 @@ func notificationHandler(ctx context.Context, app application.IntegrationInciden
 			return
 		}
 
+		// the broker tells which of its tenants the notification is for
+		tenant := r.Header.Get("NGSILD-Tenant")
+
 		for _, n := range notif.Data {
 			switch n.Type {
 			case "Device":
-				if n.DeviceState != nil && strings.Contains(n.Id, "se:servanet:lora:msva:") {
+				if n.DeviceState != nil {
 					code, _ := strconv.Atoi(n.DeviceState.Value)
 					s := models.NewStatusMessage(n.Id, code)
+					s.Tenant = tenant
 					// TODO: remove code block?
 					err = app.DeviceStateUpdated(ctx, n.Id, s)
 				}
 			case "Lifebuoy":
 				if n.Status != nil {
-					err = app.LifebuoyValueUpdated(ctx, n.Id, n.Status.Value)
+					err = app.LifebuoyValueUpdated(ctx, tenant, n.Id, n.Status.Value)
+				}
+			case "WeatherObserved":
+				if n.Temperature != nil {
+					observedAt := n.Temperature.ObservedAt
+					if observedAt == "" {
+						observedAt = notif.NotifiedAt
+					}
+					// the app uses the current time if neither can be parsed
+					t, _ := time.Parse(time.RFC3339Nano, observedAt)
+					err = app.WeatherObserved(ctx, tenant, n.Id, n.Temperature.Value, t)
 				}
 			}
 		}
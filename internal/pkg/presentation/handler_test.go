@@ -3,13 +3,22 @@ package presentation
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/diwise/integration-incident/internal/pkg/application"
-	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/models"
+	"github.com/diwise/integration-incident/internal/pkg/application/categories"
+	"github.com/diwise/integration-incident/internal/pkg/application/descriptions"
+	"github.com/diwise/integration-incident/internal/pkg/application/dryrun"
+	"github.com/diwise/integration-incident/internal/pkg/application/outbox"
+	"github.com/diwise/integration-incident/internal/pkg/application/tenants"
+	"github.com/diwise/integration-incident/pkg/models"
 	"github.com/matryer/is"
 )
 
@@ -39,17 +48,19 @@ func TestNotificationHandlerTriggersDeviceStateUpdatedIfDeviceStateExists(t *tes
 	is.Equal(len(app.DeviceStateUpdatedCalls()), 1)
 }
 
-func TestNotificationHandlerDoesNotTriggersDeviceStateUpdatedIfWrongDeviceID(t *testing.T) {
+func TestNotificationHandlerLeavesDeviceFilteringToTheApp(t *testing.T) {
 	is := is.New(t)
 	app := mockApp()
 
 	r := httptest.NewRequest("POST", "/api/notify", bytes.NewBuffer([]byte(createStatusBody("notawatermeter", "104"))))
+	r.Header.Set("NGSILD-Tenant", "sundsvall")
 	w := httptest.NewRecorder()
 
 	notificationHandler(context.Background(), app).ServeHTTP(w, r)
 	is.Equal(w.Code, http.StatusOK)
 
-	is.Equal(len(app.DeviceStateUpdatedCalls()), 0)
+	is.Equal(len(app.DeviceStateUpdatedCalls()), 1)
+	is.Equal(app.DeviceStateUpdatedCalls()[0].StatusMessage.Tenant, "sundsvall")
 }
 
 func TestNotificationHandlerReturnsBadRequestIfEmptyRequestBody(t *testing.T) {
@@ -86,6 +97,222 @@ func TestNotificationHandlerHandlesUpdatedValueForLifeBuoys(t *testing.T) {
 	is.Equal(len(app.LifebuoyValueUpdatedCalls()), 1)
 }
 
+func TestNotificationHandlerPassesWeatherObservationsOn(t *testing.T) {
+	is := is.New(t)
+	app := mockApp()
+
+	r := httptest.NewRequest("POST", "/api/notify", bytes.NewBuffer([]byte(weatherObservedJson)))
+	r.Header.Set("NGSILD-Tenant", "kiruna")
+	w := httptest.NewRecorder()
+
+	notificationHandler(context.Background(), app).ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusOK)
+
+	is.Equal(len(app.WeatherObservedCalls()), 1)
+	call := app.WeatherObservedCalls()[0]
+	is.Equal(call.Tenant, "kiruna")
+	is.Equal(call.EntityId, "urn:ngsi-ld:WeatherObserved:kiruna")
+	is.Equal(call.Temperature, -2.5)
+	is.Equal(call.ObservedAt, time.Date(2024, 5, 20, 3, 0, 0, 0, time.UTC))
+}
+
+func TestCategoriesHandlerReturnsActiveMapping(t *testing.T) {
+	is := is.New(t)
+
+	r := httptest.NewRequest("GET", "/admin/categories", nil)
+	w := httptest.NewRecorder()
+
+	categoriesHandler(categories.Mapping{"watermeter": 117}).ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Body.String(), `{"watermeter":117}`)
+}
+
+func TestReadinessHandlerReportsFailingChecks(t *testing.T) {
+	is := is.New(t)
+
+	checks := []healthCheck{
+		{name: "token", check: func(ctx context.Context) (any, error) { return map[string]bool{"valid": true}, nil }},
+	}
+
+	w := httptest.NewRecorder()
+	readinessHandler(checks).ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Body.String(), `{"token":{"status":"ok","details":{"valid":true}}}`)
+
+	checks = append(checks, healthCheck{name: "broker", check: func(ctx context.Context) (any, error) { return nil, errors.New("unreachable") }})
+
+	w = httptest.NewRecorder()
+	readinessHandler(checks).ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+	is.Equal(w.Code, http.StatusServiceUnavailable)
+}
+
+func TestHealthReportsStatuses(t *testing.T) {
+	is := is.New(t)
+
+	r, err := CreateRouter(context.Background(), mockApp(), WithStatus("breaker", func(context.Context) any {
+		return map[string]string{"state": "open"}
+	}))
+	is.NoErr(err)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Body.String(), `{"breaker":{"status":"ok","details":{"state":"open"}}}`)
+}
+
+func TestOutboxEntriesCanBeListedRetriedAndDiscarded(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	o, err := outbox.New(outbox.NewInMemoryStore(), nil)
+	is.NoErr(err)
+	_, err = o.Reporter()(ctx, *models.NewIncident(17, "a description"))
+	is.NoErr(err)
+
+	r, err := CreateRouter(ctx, mockApp(), WithOutbox(o), WithAdminToken("s3cret"))
+	is.NoErr(err)
+
+	admin := func(method, target string) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/admin/outbox", nil))
+	is.Equal(w.Code, http.StatusUnauthorized)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, admin("GET", "/admin/outbox"))
+	is.Equal(w.Code, http.StatusOK)
+
+	entries := []outbox.Entry{}
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &entries))
+	is.Equal(len(entries), 1)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, admin("GET", "/admin/outbox?dead=true"))
+	is.Equal(w.Body.String(), "[]")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/admin/outbox/"+entries[0].ID+"/retry", nil))
+	is.Equal(w.Code, http.StatusUnauthorized)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, admin("POST", "/admin/outbox/"+entries[0].ID+"/retry"))
+	is.Equal(w.Code, http.StatusNoContent)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, admin("DELETE", "/admin/outbox/"+entries[0].ID))
+	is.Equal(w.Code, http.StatusNoContent)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, admin("DELETE", "/admin/outbox/"+entries[0].ID))
+	is.Equal(w.Code, http.StatusNotFound)
+}
+
+func TestAdminRoutesAreForbiddenWithoutAdminToken(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	o, err := outbox.New(outbox.NewInMemoryStore(), nil)
+	is.NoErr(err)
+
+	r, err := CreateRouter(ctx, mockApp(), WithOutbox(o))
+	is.NoErr(err)
+
+	req := httptest.NewRequest("DELETE", "/admin/outbox/1", nil)
+	req.Header.Set("Authorization", "Bearer ")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	is.Equal(w.Code, http.StatusForbidden)
+}
+
+func TestAdminRoutesRequireAdminTokenToRead(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	o, err := outbox.New(outbox.NewInMemoryStore(), nil)
+	is.NoErr(err)
+	rec, err := dryrun.NewRecorder(10)
+	is.NoErr(err)
+	templates, err := descriptions.Parse([]byte(`watermeter: "{{.Device.ShortID}}"`))
+	is.NoErr(err)
+	cfg, err := tenants.Parse([]byte("default: sundsvall\ntenants:\n  sundsvall:\n    gatewayUrl: https://gateway.sundsvall.se\n    authCode: secret\n"))
+	is.NoErr(err)
+
+	opts := []Option{WithOutbox(o), WithRecorder(rec), WithDescriptions(templates), WithCategories(categories.Default()), WithTenants(cfg)}
+
+	for token, expected := range map[string]int{"": http.StatusForbidden, "s3cret": http.StatusUnauthorized} {
+		r, err := CreateRouter(ctx, mockApp(), append(opts, WithAdminToken(token))...)
+		is.NoErr(err)
+
+		for _, path := range []string{"/admin/outbox", "/admin/dryrun", "/admin/descriptions", "/admin/categories", "/admin/tenants"} {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			is.Equal(w.Code, expected) // admin route read without a valid token
+		}
+	}
+}
+
+func TestRecorderHandlerFiltersRecords(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	rec, err := dryrun.NewRecorder(10)
+	is.NoErr(err)
+	rec.Reporter()(ctx, *models.NewIncident(15, "Livboj"))
+	rec.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+
+	w := httptest.NewRecorder()
+	recorderHandler(rec).ServeHTTP(w, httptest.NewRequest("GET", "/admin/dryrun?category=18", nil))
+	is.Equal(w.Code, http.StatusOK)
+
+	records := []dryrun.Record{}
+	is.NoErr(json.Unmarshal(w.Body.Bytes(), &records))
+	is.Equal(len(records), 1)
+	is.Equal(records[0].Incident.Description, "Bräddning")
+
+	w = httptest.NewRecorder()
+	recorderHandler(rec).ServeHTTP(w, httptest.NewRequest("GET", "/admin/dryrun?limit=-1", nil))
+	is.Equal(w.Code, http.StatusBadRequest)
+}
+
+func TestDescriptionPreview(t *testing.T) {
+	is := is.New(t)
+
+	templates, err := descriptions.Parse([]byte(`watermeter: "{{.Device.ShortID}}: {{.Event.errorType}}"`))
+	is.NoErr(err)
+
+	r, err := CreateRouter(context.Background(), mockApp(), WithDescriptions(templates), WithAdminToken("s3cret"))
+	is.NoErr(err)
+
+	preview := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/admin/descriptions/preview", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/admin/descriptions/preview", strings.NewReader(`{"kind": "watermeter"}`)))
+	is.Equal(w.Code, http.StatusUnauthorized)
+
+	w = preview(`{"kind": "watermeter"}`)
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Body.String(), `{"description":"05394167: Läckage"}`)
+
+	w = preview(`{"kind": "lifebuoy", "template": "Livboj {{.Device.ID}}", "data": {"device": {"id": "elt-livboj-01"}}}`)
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Body.String(), `{"description":"Livboj elt-livboj-01"}`)
+
+	is.Equal(preview(`{"kind": "lifebuoy"}`).Code, http.StatusNotFound)
+	is.Equal(preview(`{"kind": "watermeter", "template": "{{.Nothing}}"}`).Code, http.StatusBadRequest)
+}
+
 func createStatusBody(deviceId, state string) string {
 	return fmt.Sprintf(withDeviceStateJsonFormat, deviceId, state)
 }
@@ -99,7 +326,10 @@ func mockApp() *application.IntegrationIncidentMock {
 		DeviceStateUpdatedFunc: func(ctx context.Context, deviceId string, statusMessage models.StatusMessage) error {
 			return nil
 		},
-		LifebuoyValueUpdatedFunc: func(ctx context.Context, deviceId, deviceValue string) error {
+		LifebuoyValueUpdatedFunc: func(ctx context.Context, tenant, deviceId, deviceValue string) error {
+			return nil
+		},
+		WeatherObservedFunc: func(ctx context.Context, tenant, entityId string, temperature float64, observedAt time.Time) error {
 			return nil
 		},
 	}
@@ -150,3 +380,18 @@ const withValueJsonFormat string = `{
 		}
 	]
 }`
+const weatherObservedJson string = `{
+	"subscriptionId": "36990e41ccd84af99d8b233eca81d1d3",
+	"notifiedAt": "2024-05-20T03:05:00Z",
+	"data": [
+		{
+			"id": "urn:ngsi-ld:WeatherObserved:kiruna",
+			"type": "WeatherObserved",
+			"temperature": {
+				"type": "Property",
+				"value": -2.5,
+				"observedAt": "2024-05-20T03:00:00Z"
+			}
+		}
+	]
+}`
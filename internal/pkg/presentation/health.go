@@ -0,0 +1,94 @@
+package presentation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// HealthCheck returns details about the state of a dependency, and an error
+// if the service can not do its job because of it
+type HealthCheck func(ctx context.Context) (any, error)
+
+type healthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// WithHealthCheck adds a named check to the readiness endpoint /health/ready
+func WithHealthCheck(name string, check HealthCheck) Option {
+	return func(c *config) {
+		c.healthChecks = append(c.healthChecks, healthCheck{name: name, check: check})
+	}
+}
+
+// WithStatus adds a named status, such as the state of a circuit breaker, to
+// /health. A status never makes /health fail, as the service is still alive.
+func WithStatus(name string, status func(ctx context.Context) any) Option {
+	return func(c *config) {
+		c.statuses = append(c.statuses, healthCheck{name: name, check: func(ctx context.Context) (any, error) {
+			return status(ctx), nil
+		}})
+	}
+}
+
+type checkResult struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Details any    `json:"details,omitempty"`
+}
+
+// livenessHandler responds with the statuses, and always with 200 OK
+func livenessHandler(statuses []healthCheck) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results, _ := run(r.Context(), statuses)
+		respond(w, http.StatusOK, results)
+	})
+}
+
+// readinessHandler runs all health checks and responds with 503 if any of them fails
+func readinessHandler(checks []healthCheck) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results, ok := run(r.Context(), checks)
+
+		statusCode := http.StatusOK
+		if !ok {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		respond(w, statusCode, results)
+	})
+}
+
+// run runs the checks and reports whether all of them passed
+func run(ctx context.Context, checks []healthCheck) (map[string]checkResult, bool) {
+	results := map[string]checkResult{}
+	ok := true
+
+	for _, hc := range checks {
+		details, err := hc.check(ctx)
+
+		result := checkResult{Status: "ok", Details: details}
+		if err != nil {
+			result.Status = "error"
+			result.Error = err.Error()
+			ok = false
+		}
+
+		results[hc.name] = result
+	}
+
+	return results, ok
+}
+
+func respond(w http.ResponseWriter, statusCode int, results map[string]checkResult) {
+	b, err := json.Marshal(results)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(b)
+}
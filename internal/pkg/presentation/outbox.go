@@ -0,0 +1,81 @@
+package presentation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/outbox"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/go-chi/chi/v5"
+)
+
+// WithOutbox exposes the entries of the outbox on /admin/outbox. Entries can
+// be retried with POST /admin/outbox/{id}/retry and discarded with
+// DELETE /admin/outbox/{id}.
+func WithOutbox(o *outbox.Outbox) Option {
+	return func(c *config) {
+		c.adminRoutes = append(c.adminRoutes, func(r chi.Router) {
+			r.Get("/outbox", listOutboxHandler(o))
+			r.Post("/outbox/{id}/retry", retryOutboxHandler(o))
+			r.Delete("/outbox/{id}", discardOutboxHandler(o))
+		})
+	}
+}
+
+// listOutboxHandler responds with all entries, or only the dead letters if
+// the query parameter dead is true
+func listOutboxHandler(o *outbox.Outbox) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entries, err := o.List(r.Context())
+		if err != nil {
+			logging.GetFromContext(r.Context()).Error("failed to list outbox", "err", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("dead") == "true" {
+			dead := []outbox.Entry{}
+			for _, e := range entries {
+				if e.Dead {
+					dead = append(dead, e)
+				}
+			}
+			entries = dead
+		}
+
+		b, err := json.Marshal(entries)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	})
+}
+
+func retryOutboxHandler(o *outbox.Outbox) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := o.Retry(r.Context(), chi.URLParam(r, "id"))
+		w.WriteHeader(outboxStatusCode(err))
+	})
+}
+
+func discardOutboxHandler(o *outbox.Outbox) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := o.Discard(r.Context(), chi.URLParam(r, "id"))
+		w.WriteHeader(outboxStatusCode(err))
+	})
+}
+
+func outboxStatusCode(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusNoContent
+	case errors.Is(err, outbox.ErrNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
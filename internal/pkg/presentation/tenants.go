@@ -0,0 +1,33 @@
+package presentation
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/diwise/integration-incident/internal/pkg/application/tenants"
+	"github.com/go-chi/chi/v5"
+)
+
+// WithTenants exposes the tenant configuration, without auth codes, on
+// /admin/tenants
+func WithTenants(cfg *tenants.Config) Option {
+	return func(c *config) {
+		c.adminRoutes = append(c.adminRoutes, func(r chi.Router) {
+			r.Get("/tenants", tenantsHandler(cfg))
+		})
+	}
+}
+
+func tenantsHandler(cfg *tenants.Config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(b)
+	})
+}
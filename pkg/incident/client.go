@@ -0,0 +1,280 @@
+package incident
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
+)
+
+var ErrNoClosedStatus = errors.New("no closed status configured")
+
+// Client is a client for the incident API. It fetches an access token when it
+// is created and refreshes it ahead of expiry or when it is rejected by the API.
+// A Client is safe for concurrent use.
+type Client struct {
+	cfg    *config
+	tokens *tokenSource
+}
+
+// NewClient creates a client for the incident API at the url given with
+// WithBaseURL, authenticating with authCode
+func NewClient(ctx context.Context, authCode string, opts ...Option) (*Client, error) {
+	cfg, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		cfg:    cfg,
+		tokens: newTokenSource(cfg, authCode),
+	}
+
+	_, err = c.tokens.Token(c.logContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// TokenStatus returns the age and expiry of the current access token
+func (c *Client) TokenStatus() TokenStatus {
+	return c.tokens.Status()
+}
+
+// Create posts a new incident and returns the response from the incident API
+func (c *Client) Create(ctx context.Context, incident IncidentRequest) (*CreateResponse, error) {
+	var err error
+	ctx, span := tracer.Start(c.logContext(ctx), "post-incident")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	var incidentBytes []byte
+	incidentBytes, err = json.Marshal(incident)
+	if err != nil {
+		err = fmt.Errorf("could not marshal incident message into json: %w", err)
+		return nil, err
+	}
+
+	incidentUrl := c.incidentUrl()
+
+	log := logging.GetFromContext(ctx)
+	log.Info(fmt.Sprintf("posting incident \"%s\" (cat: %d) to: %s", incident.Description, incident.Category, incidentUrl))
+
+	response := CreateResponse{}
+
+	err = c.do(ctx, http.MethodPost, incidentUrl, incidentBytes, &response)
+	if err != nil {
+		err = fmt.Errorf("failed to post incident message: %w", err)
+		return nil, err
+	}
+
+	if !slices.Contains([]string{"SPARAT", "INSKICKAT", "KLART"}, response.Status) {
+		err = &RejectedError{StatusCode: http.StatusOK, Status: response.Status, Message: response.Message}
+		return nil, err
+	}
+
+	log.Info("incident created", "incident_id", response.IncidentID)
+
+	return &response, nil
+}
+
+func (c *Client) Get(ctx context.Context, incidentID string) (*Incident, error) {
+	var err error
+	ctx, span := tracer.Start(c.logContext(ctx), "get-incident")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	incident := Incident{}
+
+	err = c.do(ctx, http.MethodGet, c.incidentUrl()+"/"+url.PathEscape(incidentID), nil, &incident)
+	if err != nil {
+		err = fmt.Errorf("failed to get incident %s: %w", incidentID, err)
+		return nil, err
+	}
+
+	return &incident, nil
+}
+
+// List returns a page of incidents. Page numbers start at 0.
+func (c *Client) List(ctx context.Context, pageNumber, pageSize int) ([]Incident, error) {
+	var err error
+	ctx, span := tracer.Start(c.logContext(ctx), "list-incidents")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	params := url.Values{}
+	params.Add("pageNumber", strconv.Itoa(pageNumber))
+	params.Add("pageSize", strconv.Itoa(pageSize))
+
+	incidents := []Incident{}
+
+	err = c.do(ctx, http.MethodGet, c.incidentUrl()+"?"+params.Encode(), nil, &incidents)
+	if err != nil {
+		err = fmt.Errorf("failed to list incidents: %w", err)
+		return nil, err
+	}
+
+	return incidents, nil
+}
+
+// AddComment sets the feedback of an incident
+func (c *Client) AddComment(ctx context.Context, incidentID, comment string) error {
+	var err error
+	ctx, span := tracer.Start(c.logContext(ctx), "add-comment")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	params := url.Values{}
+	params.Add("feedback", comment)
+
+	err = c.do(ctx, http.MethodPatch, c.incidentUrl()+"/feedback/"+url.PathEscape(incidentID)+"?"+params.Encode(), nil, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to add comment to incident %s: %w", incidentID, err)
+		return err
+	}
+
+	return nil
+}
+
+// Close gives an incident the status configured with WithClosedStatus
+func (c *Client) Close(ctx context.Context, incidentID string) error {
+	var err error
+	ctx, span := tracer.Start(c.logContext(ctx), "close-incident")
+	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+
+	if c.cfg.closedStatus == 0 {
+		err = ErrNoClosedStatus
+		return err
+	}
+
+	params := url.Values{}
+	params.Add("status", strconv.Itoa(c.cfg.closedStatus))
+
+	err = c.do(ctx, http.MethodPatch, c.incidentUrl()+"/status/"+url.PathEscape(incidentID)+"?"+params.Encode(), nil, nil)
+	if err != nil {
+		err = fmt.Errorf("failed to close incident %s: %w", incidentID, err)
+		return err
+	}
+
+	logging.GetFromContext(ctx).Info("incident closed", "incident_id", incidentID)
+
+	return nil
+}
+
+func (c *Client) incidentUrl() string {
+	return c.cfg.baseURL + c.cfg.incidentPath()
+}
+
+// logContext stores the logger set with WithLogger in the context, if any
+func (c *Client) logContext(ctx context.Context) context.Context {
+	if c.cfg.logger == nil {
+		return ctx
+	}
+	return logging.NewContextWithLogger(ctx, c.cfg.logger)
+}
+
+// do sends a request with the current access token and, if the token is
+// rejected, once more after the token has been refreshed
+func (c *Client) do(ctx context.Context, method, requestUrl string, body []byte, result any) error {
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.send(ctx, method, requestUrl, body, token, result)
+	if errors.Is(err, ErrUnauthorized) {
+		log := logging.GetFromContext(ctx)
+		log.Error("request to incident api failed, retrying after access token refresh", "err", err.Error())
+
+		c.tokens.Invalidate(token)
+
+		token, err = c.tokens.Token(ctx)
+		if err != nil {
+			err = fmt.Errorf("failed to refresh access token: %w", err)
+			return err
+		}
+
+		return c.send(ctx, method, requestUrl, body, token, result)
+	}
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, requestUrl string, body []byte, token string, result any) error {
+	log := logging.GetFromContext(ctx)
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewBuffer(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, bodyReader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	if log.Enabled(ctx, slog.LevelDebug) {
+		dump, err := httputil.DumpRequestOut(req, true)
+		if err != nil {
+			log.Error("could not dump the request", "err", err.Error())
+		} else {
+			log.Debug(fmt.Sprintf("HTTP request: %s", dump))
+		}
+	}
+
+	resp, err := c.cfg.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: request failed: %w", ErrTransient, err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read response body: %w", ErrTransient, err)
+	}
+
+	err = classify(resp.StatusCode, responseBody)
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(responseBody, result)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+	}
+
+	return nil
+}
+
+// classify turns a response code other than 200 into one of the exported errors
+func classify(statusCode int, body []byte) error {
+	switch {
+	case statusCode == http.StatusOK:
+		return nil
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: response code %d", ErrUnauthorized, statusCode)
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: response code %d", ErrTransient, statusCode)
+	case statusCode >= http.StatusBadRequest:
+		return &RejectedError{StatusCode: statusCode, Message: string(body)}
+	}
+
+	return fmt.Errorf("%w: unexpected response code %d", ErrMalformedResponse, statusCode)
+}
@@ -0,0 +1,157 @@
+package incident
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestClientCreateGetAndList(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /token":
+			w.Write([]byte(accessTokenResp))
+		case "POST /incident/3.0/2262/incident":
+			w.Write([]byte(`{"status": "INSKICKAT", "incidentId": "SP_1"}`))
+		case "GET /incident/3.0/2262/incident/SP_1":
+			w.Write([]byte(incidentJson))
+		case "GET /incident/3.0/2262/incident":
+			is.Equal(r.URL.RawQuery, "pageNumber=0&pageSize=10")
+			w.Write([]byte("[" + incidentJson + "]"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(ctx, "",
+		WithBaseURL(server.URL),
+		WithMunicipality("2262"),
+		WithHTTPClient(server.Client()),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	is.NoErr(err)
+
+	created, err := c.Create(ctx, IncidentRequest{PersonID: "diwise", Category: 15, Description: "Livboj"})
+	is.NoErr(err)
+	is.Equal(created.IncidentID, "SP_1")
+
+	incident, err := c.Get(ctx, created.IncidentID)
+	is.NoErr(err)
+	is.Equal(incident.Status, "INSKICKAT")
+	is.Equal(incident.Category.CategoryID, 15)
+
+	incidents, err := c.List(ctx, 0, 10)
+	is.NoErr(err)
+	is.Equal(len(incidents), 1)
+}
+
+func TestClientCloseRequiresClosedStatus(t *testing.T) {
+	is := is.New(t)
+
+	server := setupMockService(http.StatusOK, accessTokenResp)
+	defer server.Close()
+
+	c, err := NewClient(context.Background(), "", WithBaseURL(server.URL))
+	is.NoErr(err)
+
+	err = c.Close(context.Background(), "SP_1")
+	is.Equal(err, ErrNoClosedStatus)
+}
+
+func TestClientRefreshesTokenWhenRejected(t *testing.T) {
+	is := is.New(t)
+
+	tokenRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			w.Write([]byte(accessTokenResp))
+			return
+		}
+		if tokenRequests == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c, err := NewClient(context.Background(), "", WithBaseURL(server.URL))
+	is.NoErr(err)
+
+	err = c.AddComment(context.Background(), "SP_1", "kommentar")
+	is.NoErr(err)
+	is.Equal(tokenRequests, 2)
+}
+
+func TestClientErrorsAreClassified(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	statusCode, body := http.StatusOK, ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Write([]byte(accessTokenResp))
+			return
+		}
+		w.WriteHeader(statusCode)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	c, err := NewClient(ctx, "", WithBaseURL(server.URL))
+	is.NoErr(err)
+
+	create := func(code int, response string) error {
+		statusCode, body = code, response
+		_, err := c.Create(ctx, IncidentRequest{PersonID: "diwise", Category: 15, Description: "Livboj"})
+		return err
+	}
+
+	err = create(http.StatusServiceUnavailable, "")
+	is.True(errors.Is(err, ErrTransient))
+	is.True(!IsPermanent(err))
+
+	err = create(http.StatusUnauthorized, "")
+	is.True(errors.Is(err, ErrUnauthorized))
+
+	err = create(http.StatusBadRequest, "invalid category")
+	is.True(errors.Is(err, ErrRejected))
+	rejected := &RejectedError{}
+	is.True(errors.As(err, &rejected))
+	is.Equal(rejected.StatusCode, http.StatusBadRequest)
+	is.Equal(rejected.Message, "invalid category")
+
+	err = create(http.StatusOK, `{"status": "AVVISAT", "message": "okänd kategori"}`)
+	is.True(errors.As(err, &rejected))
+	is.Equal(rejected.Status, "AVVISAT")
+	is.Equal(rejected.Message, "okänd kategori")
+	is.True(IsPermanent(err))
+
+	err = create(http.StatusOK, `<html>`)
+	is.True(errors.Is(err, ErrMalformedResponse))
+	is.True(IsPermanent(err))
+
+	server.Close()
+	err = create(http.StatusOK, "")
+	is.True(errors.Is(err, ErrTransient))
+}
+
+const incidentJson string = `{
+	"incidentId": "SP_1",
+	"personId": "diwise",
+	"description": "Livboj",
+	"status": "INSKICKAT",
+	"category": {"categoryId": 15, "title": "LIVBOJ", "label": "Livboj"},
+	"created": "2024-05-31T12:12:12Z"
+}`
@@ -0,0 +1,48 @@
+package incident
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnauthorized is returned when the auth code or the access token is
+	// refused, even after the token has been refreshed
+	ErrUnauthorized = errors.New("not authorized by incident api")
+	// ErrTransient is returned for failures that may go away if the request is
+	// sent again later, such as network errors and 5xx responses
+	ErrTransient = errors.New("transient failure")
+	// ErrRejected is matched by a RejectedError with errors.Is
+	ErrRejected = errors.New("rejected by incident api")
+	// ErrMalformedResponse is returned when the response could not be read. The
+	// request may or may not have been carried out by the incident API.
+	ErrMalformedResponse = errors.New("malformed response from incident api")
+)
+
+// RejectedError is returned when the incident API refuses a request, either
+// with a 4xx response code or, when creating an incident, with a status other
+// than SPARAT, INSKICKAT or KLART. Sending the same request again will fail
+// the same way.
+type RejectedError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *RejectedError) Error() string {
+	if e.Status != "" {
+		return fmt.Sprintf("%s: status \"%s\" with message \"%s\"", ErrRejected.Error(), e.Status, e.Message)
+	}
+	return fmt.Sprintf("%s: response code %d with message \"%s\"", ErrRejected.Error(), e.StatusCode, e.Message)
+}
+
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+// IsPermanent reports whether an error means that there is no point in sending
+// the request again. A malformed response counts as permanent since the
+// incident may already have been created.
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrRejected) || errors.Is(err, ErrMalformedResponse)
+}
@@ -0,0 +1,387 @@
+package incident
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrCircuitOpen = fmt.Errorf("%w: circuit breaker is open", ErrTransient)
+	ErrRateLimited = fmt.Errorf("%w: rate limit exceeded", ErrTransient)
+	ErrQueueFull   = fmt.Errorf("%w: incident queue is full", ErrTransient)
+)
+
+// ErrQueued is returned for an incident that has been put on the queue. It is
+// not posted yet, and its id is passed to the CreatedFunc of Run once it is.
+var ErrQueued = errors.New("incident queued")
+
+var errQueueNotEmpty = errors.New("incidents are waiting in the queue")
+
+const (
+	BreakerClosed   string = "closed"
+	BreakerOpen     string = "open"
+	BreakerHalfOpen string = "half-open"
+)
+
+// CreatedFunc is called with the dedup key of an incident that was posted
+// from the queue and the id it was given, which may be empty
+type CreatedFunc func(ctx context.Context, key, incidentID string) error
+
+// Guard wraps a CreatorFunc with a token bucket rate limiter and a circuit
+// breaker. The breaker opens after a number of consecutive failures and lets a
+// single incident through once it has been open for a while. If that incident
+// is posted the breaker closes again, otherwise it stays open.
+//
+// Incidents that can not be posted right away are put on a queue, if one has
+// been configured with WithQueue, that is drained by Run. Without a queue the
+// reporter fails with ErrCircuitOpen or ErrRateLimited instead. The queue is
+// kept in memory only.
+type Guard struct {
+	mx       sync.Mutex
+	reporter CreatorFunc
+	limiter  *rate.Limiter
+	cfg      guardConfig
+	state    string
+	failures int
+	opened   time.Time
+	probing  bool
+	queue    []models.Incident
+	wake     chan struct{}
+	now      func() time.Time
+}
+
+type guardConfig struct {
+	name      string
+	rate      rate.Limit
+	burst     int
+	threshold int
+	openFor   time.Duration
+	queueSize int
+}
+
+type GuardOption func(*guardConfig)
+
+// WithRateLimit sets the number of incidents that may be posted per minute,
+// and how many of them may be posted at once
+func WithRateLimit(perMinute float64, burst int) GuardOption {
+	return func(c *guardConfig) {
+		c.rate = rate.Limit(perMinute / 60)
+		c.burst = burst
+	}
+}
+
+// WithBreaker sets the number of consecutive failures that opens the breaker
+// and how long it stays open before an incident is let through again
+func WithBreaker(threshold int, openFor time.Duration) GuardOption {
+	return func(c *guardConfig) {
+		c.threshold = threshold
+		c.openFor = openFor
+	}
+}
+
+// WithName names the guard in its metrics, so that the guards of several
+// incident APIs can be told apart
+func WithName(name string) GuardOption {
+	return func(c *guardConfig) {
+		c.name = name
+	}
+}
+
+// WithQueue keeps up to size incidents that could not be posted right away. A
+// queued incident replaces one with the same dedup key.
+func WithQueue(size int) GuardOption {
+	return func(c *guardConfig) {
+		c.queueSize = size
+	}
+}
+
+func NewGuard(reporter CreatorFunc, opts ...GuardOption) (*Guard, error) {
+	cfg := guardConfig{
+		rate:      rate.Limit(1),
+		burst:     10,
+		threshold: 5,
+		openFor:   30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.rate <= 0 || cfg.burst < 1 {
+		return nil, fmt.Errorf("rate limit requires a positive rate and burst")
+	}
+
+	if cfg.threshold < 1 || cfg.openFor <= 0 {
+		return nil, fmt.Errorf("circuit breaker requires a positive threshold and duration")
+	}
+
+	if cfg.queueSize < 0 {
+		return nil, fmt.Errorf("queue size can not be negative")
+	}
+
+	g := &Guard{
+		reporter: reporter,
+		limiter:  rate.NewLimiter(cfg.rate, cfg.burst),
+		cfg:      cfg,
+		state:    BreakerClosed,
+		wake:     make(chan struct{}, 1),
+		now:      time.Now,
+	}
+
+	err := g.registerMetrics()
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Reporter returns a CreatorFunc that posts the incident if the breaker and
+// the rate limit allow it, and fails with ErrQueued if it is queued instead
+func (g *Guard) Reporter() CreatorFunc {
+	return func(ctx context.Context, incident models.Incident) (string, error) {
+		if _, err := g.allow(false); err != nil {
+			return "", g.enqueue(ctx, incident, err)
+		}
+
+		return g.post(ctx, incident)
+	}
+}
+
+// Run posts the queued incidents, in the order they were queued, until the
+// context is cancelled
+func (g *Guard) Run(ctx context.Context, created CreatedFunc) {
+	log := logging.GetFromContext(ctx)
+
+	for {
+		wait := time.Hour
+
+		incident, ok := g.peek()
+		if ok {
+			wait, _ = g.allow(true)
+		}
+
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-g.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		incidentID, err := g.post(ctx, incident)
+		if err != nil && !IsPermanent(err) {
+			// stays first in the queue until the breaker lets it through again
+			continue
+		}
+
+		g.pop()
+
+		if err != nil {
+			log.Error("queued incident was rejected", "key", incident.Key, "err", err.Error())
+			continue
+		}
+
+		if created != nil && incident.Key != "" {
+			err = created(ctx, incident.Key, incidentID)
+			if err != nil {
+				log.Error("failed to record queued incident", "key", incident.Key, "incident_id", incidentID, "err", err.Error())
+			}
+		}
+	}
+}
+
+// BreakerStatus is the state of the circuit breaker and the queue
+type BreakerStatus struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	Opened   time.Time `json:"opened,omitzero"`
+	Queued   int       `json:"queued"`
+}
+
+func (g *Guard) Status() BreakerStatus {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	status := BreakerStatus{State: g.state, Failures: g.failures, Queued: len(g.queue)}
+	if g.state != BreakerClosed {
+		status.Opened = g.opened
+	}
+
+	return status
+}
+
+// allow returns an error if an incident may not be posted now, together with
+// how long to wait before trying again. Incidents that are not queued must
+// wait for the queue to be drained first.
+func (g *Guard) allow(queued bool) (time.Duration, error) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	now := g.now()
+
+	if !queued && len(g.queue) > 0 {
+		return g.cfg.openFor, errQueueNotEmpty
+	}
+
+	switch g.state {
+	case BreakerOpen:
+		if reopens := g.opened.Add(g.cfg.openFor); now.Before(reopens) {
+			return reopens.Sub(now), ErrCircuitOpen
+		}
+		g.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if g.probing {
+			return g.cfg.openFor, ErrCircuitOpen
+		}
+	}
+
+	r := g.limiter.ReserveN(now, 1)
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return d, ErrRateLimited
+	}
+
+	if g.state == BreakerHalfOpen {
+		g.probing = true
+	}
+
+	return 0, nil
+}
+
+func (g *Guard) post(ctx context.Context, incident models.Incident) (string, error) {
+	incidentID, err := g.reporter(ctx, incident)
+	g.record(ctx, err)
+	return incidentID, err
+}
+
+// record updates the breaker with the outcome of a post. Rejected incidents
+// say nothing about the health of the incident API and count as successes.
+func (g *Guard) record(ctx context.Context, err error) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	g.probing = false
+
+	if err == nil || IsPermanent(err) {
+		if g.state != BreakerClosed {
+			logging.GetFromContext(ctx).Info("circuit breaker closed", "queued", len(g.queue))
+			g.notify()
+		}
+		g.state = BreakerClosed
+		g.failures = 0
+		return
+	}
+
+	g.failures++
+
+	if g.state == BreakerHalfOpen || (g.state == BreakerClosed && g.failures >= g.cfg.threshold) {
+		logging.GetFromContext(ctx).Warn("circuit breaker opened", "failures", g.failures, "err", err.Error())
+		g.state = BreakerOpen
+		g.opened = g.now()
+		breakerOpened.Add(ctx, 1, metric.WithAttributes(g.attributes()...))
+	}
+}
+
+func (g *Guard) enqueue(ctx context.Context, incident models.Incident, reason error) error {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if g.cfg.queueSize == 0 {
+		return reason
+	}
+
+	// the latest incident for a key replaces the queued one, as it would be
+	// reported again for every event until it has been posted
+	for i, queued := range g.queue {
+		if incident.Key != "" && queued.Key == incident.Key {
+			g.queue[i] = incident
+			return ErrQueued
+		}
+	}
+
+	if len(g.queue) >= g.cfg.queueSize {
+		return ErrQueueFull
+	}
+
+	g.queue = append(g.queue, incident)
+	g.notify()
+
+	logging.GetFromContext(ctx).Info("incident queued", "key", incident.Key, "queued", len(g.queue), "reason", reason.Error())
+
+	return ErrQueued
+}
+
+func (g *Guard) peek() (models.Incident, bool) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if len(g.queue) == 0 {
+		return models.Incident{}, false
+	}
+	return g.queue[0], true
+}
+
+func (g *Guard) pop() {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	g.queue = g.queue[1:]
+}
+
+func (g *Guard) notify() {
+	select {
+	case g.wake <- struct{}{}:
+	default:
+	}
+}
+
+var meter = otel.Meter("integration-incident/incident")
+
+var breakerOpened, _ = meter.Int64Counter("incident.breaker.opened",
+	metric.WithDescription("Number of times the circuit breaker around the incident API has opened"))
+
+// registerMetrics reports the state of the breaker as 0 (closed), 1 (half-open)
+// or 2 (open), together with the length of the queue
+func (g *Guard) registerMetrics() error {
+	state, err := meter.Int64ObservableGauge("incident.breaker.state",
+		metric.WithDescription("State of the circuit breaker around the incident API, 0 closed, 1 half-open and 2 open"))
+	if err != nil {
+		return err
+	}
+
+	queued, err := meter.Int64ObservableGauge("incident.queue.length",
+		metric.WithDescription("Number of incidents waiting to be posted"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		status := g.Status()
+		attrs := metric.WithAttributes(g.attributes()...)
+		o.ObserveInt64(state, map[string]int64{BreakerClosed: 0, BreakerHalfOpen: 1, BreakerOpen: 2}[status.State], attrs)
+		o.ObserveInt64(queued, int64(status.Queued), attrs)
+		return nil
+	}, state, queued)
+
+	return err
+}
+
+func (g *Guard) attributes() []attribute.KeyValue {
+	if g.cfg.name == "" {
+		return nil
+	}
+	return []attribute.KeyValue{attribute.String("guard", g.cfg.name)}
+}
@@ -0,0 +1,177 @@
+package incident
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/matryer/is"
+)
+
+func TestThatRateLimitIsApplied(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	gw := &flakyGateway{}
+	g, now := newTestGuard(is, gw, WithRateLimit(1, 2))
+
+	for range 2 {
+		_, err := g.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+		is.NoErr(err)
+	}
+
+	_, err := g.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+	is.True(errors.Is(err, ErrRateLimited))
+	is.True(errors.Is(err, ErrTransient))
+
+	*now = now.Add(time.Minute)
+	_, err = g.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+	is.NoErr(err)
+	is.Equal(gw.calls(), 3)
+}
+
+func TestThatBreakerOpensAndClosesAgain(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	gw := &flakyGateway{failing: true}
+	g, now := newTestGuard(is, gw, WithBreaker(2, time.Minute))
+
+	for range 2 {
+		_, err := g.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+		is.True(errors.Is(err, ErrTransient))
+	}
+
+	is.Equal(g.Status().State, BreakerOpen)
+
+	_, err := g.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+	is.True(errors.Is(err, ErrCircuitOpen))
+	is.Equal(gw.calls(), 2)
+
+	// a failing probe opens the breaker again
+	*now = now.Add(time.Minute)
+	_, err = g.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+	is.True(err != nil && !errors.Is(err, ErrCircuitOpen))
+	is.Equal(g.Status().State, BreakerOpen)
+
+	gw.setFailing(false)
+	*now = now.Add(time.Minute)
+	_, err = g.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+	is.NoErr(err)
+	is.Equal(g.Status().State, BreakerClosed)
+}
+
+func TestThatQueuedIncidentsAreDrainedWhenBreakerCloses(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	gw := &flakyGateway{failing: true}
+	g, now := newTestGuard(is, gw, WithBreaker(1, time.Minute), WithQueue(10))
+
+	_, err := g.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+	is.True(err != nil)
+
+	for _, key := range []string{"overflow-1", "overflow-2"} {
+		i := models.NewIncident(18, "Bräddning")
+		i.Key = key
+
+		incidentID, err := g.Reporter()(ctx, *i)
+		is.True(errors.Is(err, ErrQueued))
+		is.True(!errors.Is(err, ErrTransient))
+		is.Equal(incidentID, "")
+	}
+
+	// an incident reported again for a key replaces the queued one
+	again := models.NewIncident(18, "Bräddning igen")
+	again.Key = "overflow-1"
+	_, err = g.Reporter()(ctx, *again)
+	is.True(errors.Is(err, ErrQueued))
+	is.Equal(g.Status().Queued, 2)
+
+	gw.setFailing(false)
+	*now = now.Add(time.Minute)
+
+	mx := sync.Mutex{}
+	created := map[string]string{}
+
+	go g.Run(ctx, func(_ context.Context, key, incidentID string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		created[key] = incidentID
+		return nil
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); g.Status().Queued > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	is.Equal(created, map[string]string{"overflow-1": "SP_2", "overflow-2": "SP_3"})
+	is.Equal(g.Status().State, BreakerClosed)
+}
+
+func TestThatIncidentsAreNotQueuedWithoutAQueue(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	gw := &flakyGateway{failing: true}
+	g, _ := newTestGuard(is, gw, WithBreaker(1, time.Minute))
+
+	_, err := g.Reporter()(ctx, *models.NewIncident(18, "Bräddning"))
+	is.True(errors.Is(err, ErrTransient))
+
+	i := models.NewIncident(18, "Bräddning")
+	i.Key = "overflow-1"
+
+	_, err = g.Reporter()(ctx, *i)
+	is.True(errors.Is(err, ErrCircuitOpen))
+	is.True(errors.Is(err, ErrTransient)) // so that the caller tries again later
+	is.True(!IsPermanent(err))
+	is.True(!errors.Is(err, ErrQueued))
+	is.Equal(g.Status().Queued, 0)
+}
+
+func newTestGuard(is *is.I, gw *flakyGateway, opts ...GuardOption) (*Guard, *time.Time) {
+	g, err := NewGuard(gw.report, opts...)
+	is.NoErr(err)
+
+	now := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+
+	return g, &now
+}
+
+type flakyGateway struct {
+	mx      sync.Mutex
+	failing bool
+	count   int
+}
+
+func (f *flakyGateway) report(context.Context, models.Incident) (string, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	f.count++
+	if f.failing {
+		return "", fmt.Errorf("%w: response code 503", ErrTransient)
+	}
+	return fmt.Sprintf("SP_%d", f.count), nil
+}
+
+func (f *flakyGateway) setFailing(failing bool) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.failing = failing
+}
+
+func (f *flakyGateway) calls() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.count
+}
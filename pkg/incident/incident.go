@@ -1,129 +1,100 @@
 package incident
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
-	"net/http"
-	"net/http/httputil"
-	"slices"
-	"time"
-
-	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/models"
-	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
-	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/tracing"
-	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
-)
 
-var errNotAuthorized = errors.New("invalid auth code or token refresh required")
+	"github.com/diwise/integration-incident/pkg/models"
+)
 
+// ReporterFunc posts an incident
 type ReporterFunc func(context.Context, models.Incident) error
 
-var httpClient = http.Client{
-	Transport: otelhttp.NewTransport(http.DefaultTransport),
-	Timeout:   10 * time.Second,
-}
-
-func NewIncidentReporter(ctx context.Context, gatewayUrl, authCode string) (ReporterFunc, error) {
-	token, err := getAccessToken(ctx, gatewayUrl, authCode)
-	if err != nil {
-		return nil, err
-	}
+// CreatorFunc posts an incident and returns the id it was given by the incident API
+type CreatorFunc func(context.Context, models.Incident) (string, error)
 
+// Reporter adapts a CreatorFunc to a ReporterFunc, for callers that have no
+// use for the id of the incident
+func (f CreatorFunc) Reporter() ReporterFunc {
 	return func(ctx context.Context, incident models.Incident) error {
-		err := postIncident(ctx, incident, gatewayUrl, token.AccessToken)
-		if err == errNotAuthorized {
-			log := logging.GetFromContext(ctx)
-			log.Error("post incident failed, retrying after access token refresh", "err", err.Error())
-
-			newToken, err := getAccessToken(ctx, gatewayUrl, authCode)
-			if err != nil {
-				err = fmt.Errorf("failed to refresh access token: %w", err)
-				return err
-			}
-
-			token = newToken
-			return postIncident(ctx, incident, gatewayUrl, token.AccessToken)
-		}
+		_, err := f(ctx, incident)
 		return err
-	}, nil
+	}
 }
 
-func postIncident(ctx context.Context, incident models.Incident, gatewayUrl, token string) error {
-	var err error
-	ctx, span := tracer.Start(ctx, "post-incident")
-	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
+// ResolverFunc adds a resolution note to a previously reported incident and,
+// if a closed status has been configured, closes it
+type ResolverFunc func(ctx context.Context, incidentID, note string) error
 
-	var incidentBytes []byte
-	incidentBytes, err = json.Marshal(incident)
+func NewIncidentReporter(ctx context.Context, gatewayUrl, authCode string, opts ...Option) (ReporterFunc, error) {
+	c, err := NewClient(ctx, authCode, append([]Option{WithBaseURL(gatewayUrl)}, opts...)...)
 	if err != nil {
-		err = fmt.Errorf("could not marshal incident message into json: %w", err)
-		return err
+		return nil, err
 	}
 
-	// TODO: Make the municipality code (2281) configurable
-	gatewayUrl = gatewayUrl + "/incident/3.0/2281/incident"
-
-	log := logging.GetFromContext(ctx)
-	log.Info(fmt.Sprintf("posting incident \"%s\" (cat: %d) to: %s", incident.Description, incident.Category, gatewayUrl))
-
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, gatewayUrl, bytes.NewBuffer(incidentBytes))
-	req.Header.Add("Authorization", "Bearer "+token)
-	req.Header.Add("Content-Type", "application/json")
+	return c.Reporter(), nil
+}
 
-	dump, err := httputil.DumpRequestOut(req, true)
+func NewIncidentCreator(ctx context.Context, gatewayUrl, authCode string, opts ...Option) (CreatorFunc, error) {
+	c, err := NewClient(ctx, authCode, append([]Option{WithBaseURL(gatewayUrl)}, opts...)...)
 	if err != nil {
-		log.Error("could not dump the request", "err", err.Error())
-	} else {
-		log.Debug(fmt.Sprintf("HTTP request: %s", dump))
+		return nil, err
 	}
 
-	resp, err := httpClient.Do(req)
+	return c.Creator(), nil
+}
+
+func NewIncidentResolver(ctx context.Context, gatewayUrl, authCode string, opts ...Option) (ResolverFunc, error) {
+	c, err := NewClient(ctx, authCode, append([]Option{WithBaseURL(gatewayUrl)}, opts...)...)
 	if err != nil {
-		err = fmt.Errorf("failed to post incident message: %w", err)
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		err = errNotAuthorized
-		return err
-	}
+	return c.Resolver(), nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("bad response code from backend: %d", resp.StatusCode)
-		return err
-	}
+// Reporter adapts the client to a ReporterFunc
+func (c *Client) Reporter() ReporterFunc {
+	return c.Creator().Reporter()
+}
 
-	var responseBody []byte
-	responseBody, err = io.ReadAll(resp.Body)
-	if err != nil {
-		err = fmt.Errorf("failed to read response body: %w", err)
-		return err
+// Creator adapts the client to a CreatorFunc
+func (c *Client) Creator() CreatorFunc {
+	return func(ctx context.Context, incident models.Incident) (string, error) {
+		response, err := c.Create(ctx, newIncidentRequest(incident))
+		if err != nil {
+			return "", err
+		}
+		return response.IncidentID, nil
 	}
+}
 
-	response := incidentResponse{}
-	err = json.Unmarshal(responseBody, &response)
-	if err != nil {
-		err = fmt.Errorf("failed to unmarshal incident response: %w", err)
-		return err
-	}
+// Resolver adapts the client to a ResolverFunc
+func (c *Client) Resolver() ResolverFunc {
+	return func(ctx context.Context, incidentID, note string) error {
+		if note != "" {
+			err := c.AddComment(ctx, incidentID, note)
+			if err != nil {
+				return err
+			}
+		}
 
-	if !slices.Contains([]string{"SPARAT", "INSKICKAT", "KLART"}, response.Status) {
-		err = fmt.Errorf("incident backend returned status \"%s\" with message \"%s\"", response.Status, response.Message)
+		err := c.Close(ctx, incidentID)
+		if errors.Is(err, ErrNoClosedStatus) {
+			return nil
+		}
 		return err
 	}
-
-	log.Info("incident created", "incident_id", response.IncidentID)
-
-	return nil
 }
 
-type incidentResponse struct {
-	Status     string `json:"status"`
-	IncidentID string `json:"incidentId"`
-	Message    string `json:"message"`
+func newIncidentRequest(incident models.Incident) IncidentRequest {
+	req := IncidentRequest{
+		PersonID:       incident.PersonId,
+		Category:       incident.Category,
+		Description:    incident.Description,
+		MapCoordinates: incident.MapCoordinates,
+		Attachments:    []AttachmentRequest{},
+	}
+
+	return req
 }
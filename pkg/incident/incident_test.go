@@ -7,7 +7,8 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/diwise/integration-incident/internal/pkg/infrastructure/repositories/models"
+	"github.com/diwise/integration-incident/pkg/models"
+	"github.com/matryer/is"
 )
 
 func TestPostLifebuouyIncident(t *testing.T) {
@@ -29,6 +30,101 @@ func TestPostLifebuouyIncident(t *testing.T) {
 	}
 }
 
+func TestPostIncidentForConfiguredMunicipality(t *testing.T) {
+	is := is.New(t)
+
+	paths := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/token" {
+			w.Write([]byte(accessTokenResp))
+			return
+		}
+		w.Write([]byte(`{"status": "INSKICKAT", "incidentId": "SP_20210819_415b"}`))
+	}))
+	defer server.Close()
+
+	incidentReporter, err := NewIncidentReporter(context.Background(), server.URL, "",
+		WithMunicipality("2262"),
+		WithAPIVersion("3.1"),
+	)
+	is.NoErr(err)
+
+	err = incidentReporter(context.Background(), models.Incident{Category: 5, Description: "description"})
+	is.NoErr(err)
+
+	is.Equal(paths, []string{"/token", "/incident/3.1/2262/incident"})
+}
+
+func TestPostIncidentWithCustomPathTemplate(t *testing.T) {
+	is := is.New(t)
+
+	var incidentPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Write([]byte(accessTokenResp))
+			return
+		}
+		incidentPath = r.URL.Path
+		w.Write([]byte(`{"status": "INSKICKAT", "incidentId": "SP_20210819_415b"}`))
+	}))
+	defer server.Close()
+
+	incidentReporter, err := NewIncidentReporter(context.Background(), server.URL, "",
+		WithPathTemplate("/api/v{version}/municipalities/{municipality}/incidents"),
+	)
+	is.NoErr(err)
+
+	err = incidentReporter(context.Background(), models.Incident{Category: 5, Description: "description"})
+	is.NoErr(err)
+
+	is.Equal(incidentPath, "/api/v3.0/municipalities/2281/incidents")
+}
+
+func TestThatInvalidOptionsAreRejected(t *testing.T) {
+	is := is.New(t)
+
+	server := setupMockService(http.StatusOK, accessTokenResp)
+	defer server.Close()
+
+	for _, opt := range []Option{
+		WithMunicipality("22810"),
+		WithMunicipality(""),
+		WithAPIVersion("latest"),
+		WithPathTemplate("incident/{version}"),
+		WithPathTemplate("/incident/{version}/{kommun}/incident"),
+	} {
+		_, err := NewIncidentReporter(context.Background(), server.URL, "", opt)
+		is.True(err != nil)
+	}
+}
+
+func TestResolveIncidentAddsNoteAndClosesIt(t *testing.T) {
+	is := is.New(t)
+
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Write([]byte(accessTokenResp))
+			return
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	resolver, err := NewIncidentResolver(context.Background(), server.URL, "", WithClosedStatus(3))
+	is.NoErr(err)
+
+	err = resolver(context.Background(), "SP_20210819_415b", "Livbojen är tillbaka")
+	is.NoErr(err)
+
+	is.Equal(requests, []string{
+		"PATCH /incident/3.0/2281/incident/feedback/SP_20210819_415b?feedback=Livbojen+%C3%A4r+tillbaka",
+		"PATCH /incident/3.0/2281/incident/status/SP_20210819_415b?status=3",
+	})
+}
+
 func setupMockService(responseCode int, _ string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(r.URL.Path, "token") {
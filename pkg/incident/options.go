@@ -0,0 +1,161 @@
+package incident
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const (
+	DefaultMunicipality string = "2281"
+	DefaultAPIVersion   string = "3.0"
+	DefaultPathTemplate string = "/incident/{version}/{municipality}/incident"
+)
+
+type config struct {
+	baseURL      string
+	municipality string
+	apiVersion   string
+	pathTemplate string
+	closedStatus int
+	httpClient   *http.Client
+	logger       *slog.Logger
+
+	tokenRefreshMargin time.Duration
+	tokenRetries       int
+}
+
+type Option func(*config)
+
+// WithBaseURL sets the url of the API gateway that the incident API is exposed through
+func WithBaseURL(baseURL string) Option {
+	return func(c *config) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *config) {
+		c.httpClient = client
+	}
+}
+
+// WithLogger sets the logger used by the client. Unless set, the logger is
+// taken from the context of each call.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithMunicipality sets the municipality code that incidents are posted for
+func WithMunicipality(code string) Option {
+	return func(c *config) {
+		c.municipality = code
+	}
+}
+
+func WithAPIVersion(version string) Option {
+	return func(c *config) {
+		c.apiVersion = version
+	}
+}
+
+// WithPathTemplate sets the path, relative to the gateway url, that incidents
+// are posted to. The placeholders {version} and {municipality} are replaced
+// with the configured values.
+func WithPathTemplate(template string) Option {
+	return func(c *config) {
+		c.pathTemplate = template
+	}
+}
+
+// WithClosedStatus sets the status id that resolved incidents are given. Unless
+// set, resolved incidents only get a resolution note and are left open.
+func WithClosedStatus(statusID int) Option {
+	return func(c *config) {
+		c.closedStatus = statusID
+	}
+}
+
+// WithTokenRefresh sets how long before expiry the access token is refreshed
+// and how many attempts are made to fetch a token before giving up
+func WithTokenRefresh(margin time.Duration, attempts int) Option {
+	return func(c *config) {
+		c.tokenRefreshMargin = margin
+		c.tokenRetries = attempts
+	}
+}
+
+func newConfig(opts ...Option) (*config, error) {
+	cfg := &config{
+		municipality: DefaultMunicipality,
+		apiVersion:   DefaultAPIVersion,
+		pathTemplate: DefaultPathTemplate,
+		httpClient: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   10 * time.Second,
+		},
+		tokenRefreshMargin: 1 * time.Minute,
+		tokenRetries:       3,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return cfg, cfg.validate()
+}
+
+var (
+	municipalityPattern = regexp.MustCompile(`^[0-9]{4}$`)
+	versionPattern      = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+)
+
+func (c *config) validate() error {
+	if !strings.HasPrefix(c.baseURL, "http://") && !strings.HasPrefix(c.baseURL, "https://") {
+		return fmt.Errorf("invalid base url \"%s\"", c.baseURL)
+	}
+
+	if c.httpClient == nil {
+		return fmt.Errorf("http client can not be nil")
+	}
+
+	if c.tokenRefreshMargin < 0 || c.tokenRetries < 1 {
+		return fmt.Errorf("invalid token refresh settings")
+	}
+
+	if !municipalityPattern.MatchString(c.municipality) {
+		return fmt.Errorf("invalid municipality code \"%s\", expected four digits", c.municipality)
+	}
+
+	if !versionPattern.MatchString(c.apiVersion) {
+		return fmt.Errorf("invalid api version \"%s\"", c.apiVersion)
+	}
+
+	if c.closedStatus < 0 {
+		return fmt.Errorf("invalid closed status %d", c.closedStatus)
+	}
+
+	if !strings.HasPrefix(c.pathTemplate, "/") {
+		return fmt.Errorf("path template \"%s\" must start with /", c.pathTemplate)
+	}
+
+	if strings.Contains(c.incidentPath(), "{") {
+		return fmt.Errorf("path template \"%s\" contains unknown placeholders", c.pathTemplate)
+	}
+
+	return nil
+}
+
+func (c *config) incidentPath() string {
+	return strings.NewReplacer(
+		"{version}", c.apiVersion,
+		"{municipality}", c.municipality,
+	).Replace(c.pathTemplate)
+}
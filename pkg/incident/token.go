@@ -16,7 +16,7 @@ import (
 
 var tracer = otel.Tracer("integration-incident/token")
 
-func getAccessToken(ctx context.Context, gatewayUrl, authCode string) (*tokenResponse, error) {
+func getAccessToken(ctx context.Context, httpClient *http.Client, gatewayUrl, authCode string) (*tokenResponse, error) {
 	var err error
 	ctx, span := tracer.Start(ctx, "token-refresh")
 	defer func() { tracing.RecordAnyErrorAndEndSpan(err, span) }()
@@ -41,13 +41,23 @@ func getAccessToken(ctx context.Context, gatewayUrl, authCode string) (*tokenRes
 	var resp *http.Response
 	resp, err = httpClient.Do(req)
 	if err != nil {
+		err = fmt.Errorf("%w: token request failed: %w", ErrTransient, err)
 		log.Error("request failed", "err", err.Error())
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	// a timeout or rate limit says nothing about the credentials
+	retryable := resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+
+	if !retryable && resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+		err = fmt.Errorf("%w: invalid response %d from token endpoint", ErrUnauthorized, resp.StatusCode)
+		log.Error("bad response", "err", err.Error())
+		return nil, err
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("invalid response %d from token endpoint", resp.StatusCode)
+		err = fmt.Errorf("%w: invalid response %d from token endpoint", ErrTransient, resp.StatusCode)
 		log.Error("bad response", "err", err.Error())
 		return nil, err
 	}
@@ -55,7 +65,7 @@ func getAccessToken(ctx context.Context, gatewayUrl, authCode string) (*tokenRes
 	var bodyBytes []byte
 	bodyBytes, err = io.ReadAll(resp.Body)
 	if err != nil {
-		err = fmt.Errorf("failed to read response body (%w)", err)
+		err = fmt.Errorf("%w: failed to read response body (%w)", ErrTransient, err)
 		log.Error("i/o error", "err", err.Error())
 		return nil, err
 	}
@@ -64,6 +74,7 @@ func getAccessToken(ctx context.Context, gatewayUrl, authCode string) (*tokenRes
 
 	err = json.Unmarshal(bodyBytes, &token)
 	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrMalformedResponse, err)
 		log.Error("failed to unmarshal access token json", "err", err.Error())
 		return nil, err
 	}
@@ -0,0 +1,223 @@
+package incident
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/diwise/service-chassis/pkg/infrastructure/o11y/logging"
+)
+
+// TokenStatus describes the access token currently held by a client. A token
+// that has expired is replaced on demand, so the status only turns into an
+// error once an attempt to replace it has failed.
+type TokenStatus struct {
+	Fetched      time.Time `json:"fetched"`
+	Expires      time.Time `json:"expires,omitzero"`
+	Age          string    `json:"age"`
+	ExpiresIn    string    `json:"expiresIn,omitempty"`
+	Valid        bool      `json:"valid"`
+	RefreshError string    `json:"refreshError,omitempty"`
+}
+
+var ErrTokenExpired = errors.New("no valid access token")
+
+// Err returns ErrTokenExpired if the token can no longer be used and the last
+// attempt to refresh it failed
+func (s TokenStatus) Err() error {
+	if !s.Valid && s.RefreshError != "" {
+		return fmt.Errorf("%w: %s", ErrTokenExpired, s.RefreshError)
+	}
+	return nil
+}
+
+// tokenSource hands out access tokens and refreshes them ahead of expiry. Only
+// one refresh runs at a time, and concurrent callers wait for it instead of
+// starting one each. The lock is never held while the token endpoint is
+// called, so the status can be read during a slow refresh.
+type tokenSource struct {
+	mx         sync.Mutex
+	cfg        *config
+	authCode   string
+	token      *tokenResponse
+	fetched    time.Time
+	expires    time.Time
+	invalid    bool
+	refreshing chan struct{}
+	refreshErr error
+	retryDelay time.Duration
+	now        func() time.Time
+}
+
+func newTokenSource(cfg *config, authCode string) *tokenSource {
+	return &tokenSource{
+		cfg:        cfg,
+		authCode:   authCode,
+		retryDelay: 500 * time.Millisecond,
+		now:        time.Now,
+	}
+}
+
+// Token returns the current access token, refreshing it first if it is
+// missing, has been rejected or is about to expire
+func (ts *tokenSource) Token(ctx context.Context) (string, error) {
+	for {
+		ts.mx.Lock()
+
+		if ts.usable() {
+			token := ts.token.AccessToken
+			ts.mx.Unlock()
+			return token, nil
+		}
+
+		done := ts.refreshing
+		if done == nil {
+			done = make(chan struct{})
+			ts.refreshing = done
+			ts.mx.Unlock()
+
+			err := ts.refresh(ctx, done)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		ts.mx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-done:
+		}
+
+		ts.mx.Lock()
+		usable, err := ts.usable(), ts.refreshErr
+		ts.mx.Unlock()
+
+		// a refresh that was abandoned by its caller leaves no error behind,
+		// in which case the next round starts a refresh of its own
+		if !usable && err != nil {
+			return "", err
+		}
+	}
+}
+
+// Invalidate marks the token as rejected by the API, unless it has already
+// been replaced by another caller
+func (ts *tokenSource) Invalidate(token string) {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	if ts.token != nil && ts.token.AccessToken == token {
+		ts.invalid = true
+	}
+}
+
+func (ts *tokenSource) Status() TokenStatus {
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	status := TokenStatus{}
+
+	if ts.refreshErr != nil {
+		status.RefreshError = ts.refreshErr.Error()
+	}
+
+	if ts.token == nil {
+		return status
+	}
+
+	now := ts.now()
+	status.Fetched = ts.fetched
+	status.Expires = ts.expires
+	status.Age = now.Sub(ts.fetched).Truncate(time.Second).String()
+	status.Valid = !ts.invalid && (ts.expires.IsZero() || now.Before(ts.expires))
+
+	if !ts.expires.IsZero() {
+		status.ExpiresIn = ts.expires.Sub(now).Truncate(time.Second).String()
+	}
+
+	return status
+}
+
+// usable reports whether the current token can be handed out as it is. The
+// caller must hold the lock.
+func (ts *tokenSource) usable() bool {
+	return ts.token != nil && !ts.invalid && !ts.expiresSoon()
+}
+
+// expiresSoon reports whether the token is within the refresh margin of its
+// expiry. Tokens without a lifetime are only refreshed when rejected.
+func (ts *tokenSource) expiresSoon() bool {
+	if ts.expires.IsZero() {
+		return false
+	}
+
+	margin := ts.cfg.tokenRefreshMargin
+	if lifetime := ts.expires.Sub(ts.fetched); margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+
+	return !ts.now().Before(ts.expires.Add(-margin))
+}
+
+// refresh fetches a new token, retrying with exponential backoff as long as
+// the token endpoint does not reject the auth code. The outcome is stored
+// before done is closed. A refresh that ends because ctx is done is not
+// recorded as a failure, since it says nothing about the token endpoint.
+func (ts *tokenSource) refresh(ctx context.Context, done chan struct{}) error {
+	token, err := ts.fetch(ctx)
+
+	ts.mx.Lock()
+	defer ts.mx.Unlock()
+
+	if err == nil {
+		ts.token = token
+		ts.invalid = false
+		ts.refreshErr = nil
+		ts.fetched = ts.now()
+		ts.expires = time.Time{}
+		if token.ExpiresIn > 0 {
+			ts.expires = ts.fetched.Add(time.Duration(token.ExpiresIn) * time.Second)
+		}
+	} else if ctx.Err() == nil {
+		ts.refreshErr = err
+	}
+
+	ts.refreshing = nil
+	close(done)
+
+	return err
+}
+
+func (ts *tokenSource) fetch(ctx context.Context) (*tokenResponse, error) {
+	var err error
+	delay := ts.retryDelay
+
+	for attempt := 1; ; attempt++ {
+		var token *tokenResponse
+		token, err = getAccessToken(ctx, ts.cfg.httpClient, ts.cfg.baseURL, ts.authCode)
+		if err == nil {
+			return token, nil
+		}
+
+		if errors.Is(err, ErrUnauthorized) || attempt >= ts.cfg.tokenRetries {
+			break
+		}
+
+		logging.GetFromContext(ctx).Warn("failed to fetch access token, retrying", "attempt", attempt, "err", err.Error())
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+
+	return nil, fmt.Errorf("failed to fetch access token: %w", err)
+}
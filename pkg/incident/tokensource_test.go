@@ -0,0 +1,202 @@
+package incident
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestTokenIsRefreshedAheadOfExpiry(t *testing.T) {
+	is := is.New(t)
+
+	server, requests := setupTokenService(http.StatusOK)
+	defer server.Close()
+
+	ts, now := newTestTokenSource(is, server.URL)
+
+	_, err := ts.Token(context.Background())
+	is.NoErr(err)
+
+	*now = now.Add(3500 * time.Second)
+	_, err = ts.Token(context.Background())
+	is.NoErr(err)
+	is.Equal(requests.Load(), int32(1))
+
+	*now = now.Add(50 * time.Second)
+	_, err = ts.Token(context.Background())
+	is.NoErr(err)
+	is.Equal(requests.Load(), int32(2))
+
+	status := ts.Status()
+	is.True(status.Valid)
+	is.Equal(status.ExpiresIn, "1h0m0s")
+}
+
+func TestConcurrentRefreshesAreSerialised(t *testing.T) {
+	is := is.New(t)
+
+	server, requests := setupTokenService(http.StatusOK)
+	defer server.Close()
+
+	ts, _ := newTestTokenSource(is, server.URL)
+
+	token, err := ts.Token(context.Background())
+	is.NoErr(err)
+
+	ts.Invalidate(token)
+
+	wg := sync.WaitGroup{}
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ts.Token(context.Background())
+			is.NoErr(err)
+		}()
+	}
+	wg.Wait()
+
+	is.Equal(requests.Load(), int32(2))
+}
+
+func TestTokenEndpointIsRetried(t *testing.T) {
+	is := is.New(t)
+
+	server, requests := setupTokenService(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	ts, _ := newTestTokenSource(is, server.URL)
+
+	_, err := ts.Token(context.Background())
+	is.True(err != nil)
+	is.Equal(requests.Load(), int32(3))
+	is.True(errors.Is(ts.Status().Err(), ErrTokenExpired))
+}
+
+func TestExpiredTokenIsReadyUntilRefreshFails(t *testing.T) {
+	is := is.New(t)
+
+	responseCode := atomic.Int32{}
+	responseCode.Store(http.StatusOK)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(responseCode.Load()))
+		w.Write([]byte(accessTokenResp))
+	}))
+	defer server.Close()
+
+	ts, now := newTestTokenSource(is, server.URL)
+
+	_, err := ts.Token(context.Background())
+	is.NoErr(err)
+
+	*now = now.Add(2 * time.Hour)
+
+	status := ts.Status()
+	is.True(!status.Valid)
+	is.NoErr(status.Err())
+
+	responseCode.Store(http.StatusServiceUnavailable)
+
+	_, err = ts.Token(context.Background())
+	is.True(err != nil)
+	is.True(errors.Is(ts.Status().Err(), ErrTokenExpired))
+
+	responseCode.Store(http.StatusOK)
+
+	_, err = ts.Token(context.Background())
+	is.NoErr(err)
+	is.NoErr(ts.Status().Err())
+}
+
+func TestStatusIsNotBlockedByRefresh(t *testing.T) {
+	is := is.New(t)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(accessTokenResp))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ts, _ := newTestTokenSource(is, server.URL)
+
+	go ts.Token(context.Background())
+
+	status := make(chan TokenStatus)
+	go func() { status <- ts.Status() }()
+
+	select {
+	case s := <-status:
+		is.NoErr(s.Err())
+	case <-time.After(time.Second):
+		t.Fatal("status blocked by refresh")
+	}
+}
+
+func TestRejectedAuthCodeIsNotRetried(t *testing.T) {
+	is := is.New(t)
+
+	server, requests := setupTokenService(http.StatusUnauthorized)
+	defer server.Close()
+
+	ts, _ := newTestTokenSource(is, server.URL)
+
+	_, err := ts.Token(context.Background())
+	is.True(err != nil)
+	is.Equal(requests.Load(), int32(1))
+}
+
+func newTestTokenSource(is *is.I, url string) (*tokenSource, *time.Time) {
+	cfg, err := newConfig(WithBaseURL(url))
+	is.NoErr(err)
+
+	now := time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC)
+
+	ts := newTokenSource(cfg, "")
+	ts.retryDelay = time.Millisecond
+	ts.now = func() time.Time { return now }
+
+	return ts, &now
+}
+
+func TestTokenEndpointErrorsAreClassified(t *testing.T) {
+	is := is.New(t)
+
+	for code, expected := range map[int]error{
+		http.StatusBadRequest:         ErrUnauthorized,
+		http.StatusUnauthorized:       ErrUnauthorized,
+		http.StatusRequestTimeout:     ErrTransient,
+		http.StatusTooManyRequests:    ErrTransient,
+		http.StatusServiceUnavailable: ErrTransient,
+	} {
+		server, _ := setupTokenService(code)
+
+		_, err := getAccessToken(context.Background(), http.DefaultClient, server.URL, "")
+		is.True(errors.Is(err, expected)) // response code is classified
+
+		server.Close()
+	}
+}
+
+func setupTokenService(responseCode int) (*httptest.Server, *atomic.Int32) {
+	requests := &atomic.Int32{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(responseCode)
+		w.Write([]byte(accessTokenResp))
+	}))
+
+	return server, requests
+}
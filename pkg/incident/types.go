@@ -0,0 +1,64 @@
+package incident
+
+import "time"
+
+// IncidentRequest is the body of a request to create an incident
+type IncidentRequest struct {
+	PersonID       string              `json:"personId"`
+	PhoneNumber    string              `json:"phoneNumber,omitempty"`
+	Email          string              `json:"email,omitempty"`
+	ContactMethod  string              `json:"contactMethod,omitempty"`
+	Category       int                 `json:"category"`
+	Description    string              `json:"description"`
+	MapCoordinates string              `json:"mapCoordinates,omitempty"`
+	ExternalCaseID string              `json:"externalCaseId,omitempty"`
+	Attachments    []AttachmentRequest `json:"attachments"`
+}
+
+type AttachmentRequest struct {
+	Category  string `json:"category"`
+	Extension string `json:"extension"`
+	MimeType  string `json:"mimeType"`
+	Note      string `json:"note,omitempty"`
+	File      string `json:"file"`
+}
+
+// CreateResponse is returned by the incident API when an incident is created
+type CreateResponse struct {
+	Status     string `json:"status"`
+	IncidentID string `json:"incidentId"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Incident is an incident as returned by the incident API
+type Incident struct {
+	IncidentID     string       `json:"incidentId"`
+	ExternalCaseID string       `json:"externalCaseId,omitempty"`
+	PersonID       string       `json:"personId,omitempty"`
+	PhoneNumber    string       `json:"phoneNumber,omitempty"`
+	Email          string       `json:"email,omitempty"`
+	ContactMethod  string       `json:"contactMethod,omitempty"`
+	Description    string       `json:"description"`
+	Status         string       `json:"status"`
+	Category       *Category    `json:"category,omitempty"`
+	Attachments    []Attachment `json:"attachments,omitempty"`
+	Created        *time.Time   `json:"created,omitempty"`
+	Updated        *time.Time   `json:"updated,omitempty"`
+}
+
+type Category struct {
+	CategoryID int    `json:"categoryId"`
+	Title      string `json:"title"`
+	Label      string `json:"label"`
+	ForwardTo  string `json:"forwardTo,omitempty"`
+	Subject    string `json:"subject,omitempty"`
+}
+
+type Attachment struct {
+	Category  string `json:"category"`
+	Name      string `json:"name"`
+	Extension string `json:"extension"`
+	MimeType  string `json:"mimeType"`
+	Note      string `json:"note,omitempty"`
+	File      string `json:"file,omitempty"`
+}
@@ -13,6 +13,7 @@ type FunctionUpdated struct {
 	SubType  string    `json:"subType"`
 	Location *location `json:"location,omitempty"`
 	Name     string    `json:"name"`
+	Tenant   string    `json:"tenant,omitempty"`
 
 	Counter *struct {
 		Counter int  `json:"counter"`
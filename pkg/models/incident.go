@@ -8,6 +8,13 @@ type Incident struct {
 	Description    string   `json:"description"`
 	MapCoordinates string   `json:"mapCoordinates"`
 	Attachments    []string `json:"attachments"`
+
+	// Key is the dedup key of the rule that reported the incident. It is
+	// not part of the request sent to the incident API.
+	Key string `json:"-"`
+	// Tenant is the tenant the incident is posted for, if the service is
+	// shared by several tenants
+	Tenant string `json:"-"`
 }
 
 func NewIncident(category int, description string) *Incident {